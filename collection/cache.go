@@ -0,0 +1,413 @@
+package collection
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/shanluzhineng/threadingx/timingwheel"
+)
+
+const (
+	defaultCacheTick  = 100 * time.Millisecond
+	defaultCacheSlots = 600
+)
+
+const (
+	// EvictionExpired means the entry is evicted since its TTL has elapsed.
+	EvictionExpired EvictionReason = iota
+	// EvictionCapacity means the entry is evicted as the least recently used
+	// one, to make room for a new entry.
+	EvictionCapacity
+	// EvictionDeleted means the entry is deleted by Cache.Del.
+	EvictionDeleted
+)
+
+var (
+	// ErrNoLoader is returned by Cache.Load if the cache has no loader.
+	ErrNoLoader = errors.New("collection: cache has no loader")
+	// ErrCacheClosed is returned by Cache.Load once the cache has been closed.
+	ErrCacheClosed = errors.New("collection: cache closed")
+
+	errLoaderPanicked = errors.New("collection: cache loader panicked")
+)
+
+type (
+	// EvictionReason tells why an entry is evicted from a Cache.
+	EvictionReason int
+
+	// Cache is a map whose entries expire after their TTLs, which are driven by
+	// a timing wheel. The least recently used entries are evicted once the
+	// number of the entries exceeds the limit set by WithMaxEntries.
+	Cache[K comparable, V any] struct {
+		lock    sync.Mutex
+		entries map[K]*cacheEntry[K, V]
+		// The entries from the most recently used to the least.
+		lru     *list.List
+		flights map[K]*cacheFlight[V]
+		closed  bool
+
+		ttl        time.Duration
+		maxEntries int
+		tw         *timingwheel.TimingWheel
+		ownWheel   bool
+		onEvict    func(key K, val V, reason EvictionReason)
+		loader     func(key K) (V, error)
+
+		hits      uint64
+		misses    uint64
+		expired   uint64
+		evicted   uint64
+		deleted   uint64
+		loadFails uint64
+	}
+
+	// CacheOption defines the method to customize a Cache.
+	CacheOption[K comparable, V any] func(options *cacheOptions[K, V])
+
+	// CacheStats is a snapshot of the statistics of a Cache.
+	CacheStats struct {
+		// Entries is the number of the entries in the cache.
+		Entries int
+		// Hits and Misses are the numbers of the lookups that found
+		// the entries or not, including the ones made by Load.
+		Hits   uint64
+		Misses uint64
+		// Expired, Evicted and Deleted are the numbers of the entries removed
+		// for each EvictionReason.
+		Expired uint64
+		Evicted uint64
+		Deleted uint64
+		// LoadFails is the number of the failed calls to the loader.
+		LoadFails uint64
+	}
+
+	cacheOptions[K comparable, V any] struct {
+		maxEntries int
+		tw         *timingwheel.TimingWheel
+		onEvict    func(key K, val V, reason EvictionReason)
+		loader     func(key K) (V, error)
+	}
+
+	cacheEntry[K comparable, V any] struct {
+		key   K
+		value V
+		elem  *list.Element
+		timer *timingwheel.Timer
+		// gen identifies the TTL of the entry, so that the timer of
+		// a replaced TTL does not expire the entry.
+		gen uint64
+	}
+
+	// cacheFlight is a call to the loader shared by the concurrent
+	// callers of Load with the same key.
+	cacheFlight[V any] struct {
+		done  chan struct{}
+		value V
+		err   error
+	}
+)
+
+// String returns the name of the reason.
+func (r EvictionReason) String() string {
+	switch r {
+	case EvictionExpired:
+		return "expired"
+	case EvictionCapacity:
+		return "evicted"
+	case EvictionDeleted:
+		return "deleted"
+	default:
+		return "unknown"
+	}
+}
+
+// WithMaxEntries customizes a Cache to hold at most n entries, the least
+// recently used ones are evicted beyond. The default 0 means no limit.
+func WithMaxEntries[K comparable, V any](n int) CacheOption[K, V] {
+	if n < 0 {
+		panic(errors.New("n must not be negative"))
+	}
+
+	return func(options *cacheOptions[K, V]) {
+		options.maxEntries = n
+	}
+}
+
+// WithCacheTimingWheel customizes a Cache to drive the TTLs by the given
+// timing wheel, which must have been started, and is not stopped by
+// Cache.Close. By default, each Cache starts a timing wheel of its own.
+func WithCacheTimingWheel[K comparable, V any](tw *timingwheel.TimingWheel) CacheOption[K, V] {
+	return func(options *cacheOptions[K, V]) {
+		options.tw = tw
+	}
+}
+
+// WithEvictionHandler customizes a Cache with the handler that is called
+// with the entries removed from the cache, along with the reasons. The
+// handler is not called for the values replaced by Set.
+func WithEvictionHandler[K comparable, V any](fn func(key K, val V, reason EvictionReason)) CacheOption[K, V] {
+	return func(options *cacheOptions[K, V]) {
+		options.onEvict = fn
+	}
+}
+
+// WithLoader customizes a Cache with the loader that Load calls on a miss.
+func WithLoader[K comparable, V any](fn func(key K) (V, error)) CacheOption[K, V] {
+	return func(options *cacheOptions[K, V]) {
+		options.loader = fn
+	}
+}
+
+// NewCache returns a Cache whose entries expire after ttl by default,
+// a ttl not greater than 0 means the entries never expire.
+func NewCache[K comparable, V any](ttl time.Duration, opts ...CacheOption[K, V]) *Cache[K, V] {
+	var options cacheOptions[K, V]
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	c := &Cache[K, V]{
+		entries:    make(map[K]*cacheEntry[K, V]),
+		lru:        list.New(),
+		flights:    make(map[K]*cacheFlight[V]),
+		ttl:        ttl,
+		maxEntries: options.maxEntries,
+		tw:         options.tw,
+		onEvict:    options.onEvict,
+		loader:     options.loader,
+	}
+	if c.tw == nil {
+		c.tw = timingwheel.NewTimingWheel(defaultCacheTick, defaultCacheSlots)
+		c.tw.Start()
+		c.ownWheel = true
+	}
+
+	return c
+}
+
+// Close stops the TTLs of the entries, and the timing wheel of the cache if
+// it has its own. The entries are kept, but do not expire anymore.
+func (c *Cache[K, V]) Close() {
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return
+	}
+
+	c.closed = true
+	for _, entry := range c.entries {
+		c.stopTimer(entry)
+	}
+	c.lock.Unlock()
+
+	if c.ownWheel {
+		c.tw.Stop()
+	}
+}
+
+// Del deletes the entry with the given key from c.
+func (c *Cache[K, V]) Del(key K) {
+	c.lock.Lock()
+	entry, ok := c.entries[key]
+	if !ok {
+		c.lock.Unlock()
+		return
+	}
+
+	c.removeLocked(entry)
+	c.deleted++
+	c.lock.Unlock()
+
+	c.notifyEvicted(entry, EvictionDeleted)
+}
+
+// Get gets the value with the given key from c, and marks it as the most
+// recently used.
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.getLocked(key)
+}
+
+// Len returns the number of the entries in c.
+func (c *Cache[K, V]) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return len(c.entries)
+}
+
+// Load gets the value with the given key from c, or loads it by the loader
+// on a miss, and sets it with the default TTL. The concurrent misses of the
+// same key share a single call to the loader. The errors are not cached.
+func (c *Cache[K, V]) Load(key K) (V, error) {
+	c.lock.Lock()
+	if val, ok := c.getLocked(key); ok {
+		c.lock.Unlock()
+		return val, nil
+	}
+
+	var zero V
+	if c.loader == nil {
+		c.lock.Unlock()
+		return zero, ErrNoLoader
+	}
+	if c.closed {
+		c.lock.Unlock()
+		return zero, ErrCacheClosed
+	}
+
+	if flight, ok := c.flights[key]; ok {
+		c.lock.Unlock()
+		<-flight.done
+		return flight.value, flight.err
+	}
+
+	flight := &cacheFlight[V]{
+		done: make(chan struct{}),
+	}
+	c.flights[key] = flight
+	c.lock.Unlock()
+
+	defer func() {
+		c.lock.Lock()
+		delete(c.flights, key)
+		c.lock.Unlock()
+		close(flight.done)
+	}()
+
+	// Report a panic of the loader to the waiting callers as an error,
+	// and let it go on in the calling goroutine.
+	flight.err = errLoaderPanicked
+	flight.value, flight.err = c.loader(key)
+	if flight.err != nil {
+		c.lock.Lock()
+		c.loadFails++
+		c.lock.Unlock()
+		return zero, flight.err
+	}
+
+	c.Set(key, flight.value)
+	return flight.value, nil
+}
+
+// Set sets the value into c with the given key and the default TTL.
+func (c *Cache[K, V]) Set(key K, value V) {
+	c.SetWithTTL(key, value, c.ttl)
+}
+
+// SetWithTTL sets the value into c with the given key, which expires after
+// ttl, a ttl not greater than 0 means it never expires. The entry becomes
+// the most recently used, and the least recently used one is evicted if c
+// holds more entries than its limit.
+func (c *Cache[K, V]) SetWithTTL(key K, value V, ttl time.Duration) {
+	c.lock.Lock()
+	entry, ok := c.entries[key]
+	if ok {
+		c.stopTimer(entry)
+		entry.value = value
+		c.lru.MoveToFront(entry.elem)
+	} else {
+		entry = &cacheEntry[K, V]{
+			key:   key,
+			value: value,
+		}
+		entry.elem = c.lru.PushFront(entry)
+		c.entries[key] = entry
+	}
+	c.startTimer(entry, ttl)
+
+	var victim *cacheEntry[K, V]
+	if c.maxEntries > 0 && len(c.entries) > c.maxEntries {
+		victim = c.lru.Back().Value.(*cacheEntry[K, V])
+		c.removeLocked(victim)
+		c.evicted++
+	}
+	c.lock.Unlock()
+
+	if victim != nil {
+		c.notifyEvicted(victim, EvictionCapacity)
+	}
+}
+
+// Stats returns a snapshot of the statistics of c.
+func (c *Cache[K, V]) Stats() CacheStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return CacheStats{
+		Entries:   len(c.entries),
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Expired:   c.expired,
+		Evicted:   c.evicted,
+		Deleted:   c.deleted,
+		LoadFails: c.loadFails,
+	}
+}
+
+// expire removes the entry once the TTL identified by gen has elapsed.
+func (c *Cache[K, V]) expire(entry *cacheEntry[K, V], gen uint64) {
+	c.lock.Lock()
+	if c.entries[entry.key] != entry || entry.gen != gen {
+		// Removed or given another TTL in the meantime.
+		c.lock.Unlock()
+		return
+	}
+
+	c.removeLocked(entry)
+	c.expired++
+	c.lock.Unlock()
+
+	c.notifyEvicted(entry, EvictionExpired)
+}
+
+func (c *Cache[K, V]) notifyEvicted(entry *cacheEntry[K, V], reason EvictionReason) {
+	if c.onEvict != nil {
+		c.onEvict(entry.key, entry.value, reason)
+	}
+}
+
+func (c *Cache[K, V]) getLocked(key K) (V, bool) {
+	entry, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		var zero V
+		return zero, false
+	}
+
+	c.hits++
+	c.lru.MoveToFront(entry.elem)
+	return entry.value, true
+}
+
+// removeLocked removes the entry from c, must be called with c.lock held.
+func (c *Cache[K, V]) removeLocked(entry *cacheEntry[K, V]) {
+	c.stopTimer(entry)
+	c.lru.Remove(entry.elem)
+	delete(c.entries, entry.key)
+}
+
+// startTimer starts the TTL of the entry, must be called with c.lock held.
+func (c *Cache[K, V]) startTimer(entry *cacheEntry[K, V], ttl time.Duration) {
+	entry.gen++
+	if ttl <= 0 || c.closed {
+		return
+	}
+
+	gen := entry.gen
+	entry.timer = c.tw.AfterFunc(ttl, func() {
+		c.expire(entry, gen)
+	})
+}
+
+// stopTimer stops the TTL of the entry, must be called with c.lock held.
+func (c *Cache[K, V]) stopTimer(entry *cacheEntry[K, V]) {
+	if entry.timer != nil {
+		entry.timer.Stop()
+		entry.timer = nil
+	}
+}
@@ -0,0 +1,253 @@
+package collection
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shanluzhineng/threadingx/timex"
+	"github.com/shanluzhineng/threadingx/timingwheel"
+)
+
+// newFakeWheel returns a started timing wheel driven by a fake clock.
+func newFakeWheel(t *testing.T) (*timingwheel.TimingWheel, timex.FakeClock) {
+	clock := timex.NewFakeClock(time.Unix(1000, 0))
+	tw := timingwheel.NewTimingWheel(time.Millisecond, 20, timingwheel.WithClock(clock))
+	tw.Start()
+	t.Cleanup(tw.Stop)
+	return tw, clock
+}
+
+// advanceWheel advances the clock once the timing wheel waits on it, that is
+// on its maintenance timer and the timer of its delay queue.
+func advanceWheel(t *testing.T, clock timex.FakeClock, d time.Duration) {
+	t.Helper()
+	eventually(t, func() bool {
+		return clock.Waiters() >= 2
+	})
+	clock.Advance(d)
+}
+
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+type eviction struct {
+	key    string
+	reason EvictionReason
+}
+
+// evictionRecorder records the evictions of a Cache in order.
+type evictionRecorder struct {
+	lock      sync.Mutex
+	evictions []eviction
+}
+
+func (r *evictionRecorder) onEvict(key string, _ int, reason EvictionReason) {
+	r.lock.Lock()
+	r.evictions = append(r.evictions, eviction{key: key, reason: reason})
+	r.lock.Unlock()
+}
+
+func (r *evictionRecorder) get() []eviction {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]eviction(nil), r.evictions...)
+}
+
+func TestCacheExpires(t *testing.T) {
+	tw, clock := newFakeWheel(t)
+	var recorder evictionRecorder
+	c := NewCache[string, int](50*time.Millisecond,
+		WithCacheTimingWheel[string, int](tw),
+		WithEvictionHandler[string, int](recorder.onEvict))
+	defer c.Close()
+
+	c.Set("default", 1)
+	c.SetWithTTL("longer", 2, 200*time.Millisecond)
+	c.SetWithTTL("forever", 3, 0)
+
+	advanceWheel(t, clock, 30*time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	if c.Len() != 3 {
+		t.Fatal("an entry expired before its TTL")
+	}
+
+	advanceWheel(t, clock, 30*time.Millisecond)
+	eventually(t, func() bool {
+		return c.Len() == 2
+	})
+	if _, ok := c.Get("default"); ok {
+		t.Fatal("the entry with the default TTL has not expired")
+	}
+	if got := recorder.get(); len(got) != 1 || got[0] != (eviction{"default", EvictionExpired}) {
+		t.Fatalf("got the evictions %v", got)
+	}
+
+	advanceWheel(t, clock, 200*time.Millisecond)
+	eventually(t, func() bool {
+		return c.Len() == 1
+	})
+	if v, ok := c.Get("forever"); !ok || v != 3 {
+		t.Fatal("the entry without TTL has expired")
+	}
+	if expired := c.Stats().Expired; expired != 2 {
+		t.Fatalf("got %d expired, want 2", expired)
+	}
+}
+
+func TestCacheResetsTTLOnSet(t *testing.T) {
+	tw, clock := newFakeWheel(t)
+	c := NewCache[string, int](50*time.Millisecond, WithCacheTimingWheel[string, int](tw))
+	defer c.Close()
+
+	c.Set("key", 1)
+	advanceWheel(t, clock, 30*time.Millisecond)
+	c.Set("key", 2)
+	advanceWheel(t, clock, 30*time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	if v, ok := c.Get("key"); !ok || v != 2 {
+		t.Fatal("the entry expired by the TTL it had before Set")
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	tw, _ := newFakeWheel(t)
+	var recorder evictionRecorder
+	c := NewCache[string, int](time.Minute,
+		WithCacheTimingWheel[string, int](tw),
+		WithMaxEntries[string, int](2),
+		WithEvictionHandler[string, int](recorder.onEvict))
+	defer c.Close()
+
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Get("a")
+	c.Set("c", 3)
+	c.Set("d", 4)
+	c.Del("d")
+	c.Del("missing")
+
+	want := []eviction{
+		{"b", EvictionCapacity},
+		{"a", EvictionCapacity},
+		{"d", EvictionDeleted},
+	}
+	got := recorder.get()
+	if len(got) != len(want) {
+		t.Fatalf("got the evictions %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got the evictions %v, want %v", got, want)
+		}
+	}
+
+	stats := c.Stats()
+	if stats.Entries != 1 || stats.Evicted != 2 || stats.Deleted != 1 {
+		t.Fatalf("got the stats %+v", stats)
+	}
+}
+
+func TestCacheLoadSingleFlight(t *testing.T) {
+	tw, _ := newFakeWheel(t)
+	var calls int32
+	releaseC := make(chan struct{})
+	c := NewCache[string, int](time.Minute,
+		WithCacheTimingWheel[string, int](tw),
+		WithLoader[string, int](func(key string) (int, error) {
+			atomic.AddInt32(&calls, 1)
+			<-releaseC
+			return len(key), nil
+		}))
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	errC := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := c.Load("key")
+			if err == nil && v != 3 {
+				err = errors.New("got a wrong value")
+			}
+			errC <- err
+		}()
+	}
+
+	eventually(t, func() bool {
+		return atomic.LoadInt32(&calls) == 1
+	})
+	time.Sleep(10 * time.Millisecond)
+	close(releaseC)
+	wg.Wait()
+	close(errC)
+	for err := range errC {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("the loader is called %d times, want 1", n)
+	}
+}
+
+func TestCacheLoadErrors(t *testing.T) {
+	tw, _ := newFakeWheel(t)
+	errLoad := errors.New("load failed")
+	c := NewCache[string, int](time.Minute,
+		WithCacheTimingWheel[string, int](tw),
+		WithLoader[string, int](func(key string) (int, error) {
+			return 0, errLoad
+		}))
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.Load("key"); err != errLoad {
+			t.Fatalf("got %v, want %v", err, errLoad)
+		}
+	}
+	if fails := c.Stats().LoadFails; fails != 2 {
+		t.Fatalf("got %d load fails, want the errors not cached", fails)
+	}
+
+	c.Close()
+	if _, err := c.Load("key"); err != ErrCacheClosed {
+		t.Fatalf("got %v, want %v", err, ErrCacheClosed)
+	}
+	noLoader := NewCache[string, int](time.Minute, WithCacheTimingWheel[string, int](tw))
+	if _, err := noLoader.Load("key"); err != ErrNoLoader {
+		t.Fatalf("got %v, want %v", err, ErrNoLoader)
+	}
+}
+
+func TestCacheStats(t *testing.T) {
+	tw, _ := newFakeWheel(t)
+	c := NewCache[string, int](time.Minute,
+		WithCacheTimingWheel[string, int](tw),
+		WithLoader[string, int](func(key string) (int, error) {
+			return 1, nil
+		}))
+	defer c.Close()
+
+	c.Set("a", 1)
+	c.Get("a")
+	c.Get("b")
+	c.Load("a")
+	c.Load("c")
+	c.Load("c")
+
+	stats := c.Stats()
+	if stats.Hits != 3 || stats.Misses != 2 || stats.Entries != 2 {
+		t.Fatalf("got the stats %+v, want 3 hits, 2 misses and 2 entries", stats)
+	}
+}
@@ -1,29 +1,80 @@
 package collection
 
 import (
+	"context"
+	"errors"
 	"sync"
 )
 
-// A Queue is a FIFO queue
-type Queue struct {
-	lock     sync.Mutex
-	elements []interface{}
-	size     int
-	head     int
-	tail     int
-	count    int
+type (
+	// A Queue is a FIFO queue
+	Queue[T any] struct {
+		lock     sync.Mutex
+		elements []T
+		size     int
+		capacity int
+		head     int
+		tail     int
+		count    int
+
+		// The number of the callers of TakeCtx waiting on notifyC, which is
+		// closed and replaced once an element is put.
+		takers  int
+		notifyC chan struct{}
+		// The number of the callers of PutCtx waiting on spaceC, which is
+		// closed and replaced once an element is taken.
+		putters int
+		spaceC  chan struct{}
+	}
+
+	// QueueOption defines the method to customize a Queue.
+	QueueOption func(options *queueOptions)
+
+	queueOptions struct {
+		capacity int
+	}
+)
+
+// WithCapacity customizes a Queue to hold at most capacity elements, Put fails
+// and PutCtx waits once the queue is full. A capacity of 0 means unbounded,
+// which is the default.
+func WithCapacity(capacity int) QueueOption {
+	if capacity < 0 {
+		panic(errors.New("capacity must not be negative"))
+	}
+
+	return func(options *queueOptions) {
+		options.capacity = capacity
+	}
 }
 
-// NewQueue returns a Queue Objects
-func NewQueue(size int) *Queue {
-	return &Queue{
-		elements: make([]interface{}, size),
+// NewQueue returns a Queue Objects, size is the initial size of the queue,
+// and the step it grows and shrinks by.
+func NewQueue[T any](size int, opts ...QueueOption) *Queue[T] {
+	if size <= 0 {
+		panic(errors.New("size must be greater than 0"))
+	}
+
+	var options queueOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	if options.capacity > 0 && size > options.capacity {
+		size = options.capacity
+	}
+
+	return &Queue[T]{
+		elements: make([]T, size),
 		size:     size,
+		capacity: options.capacity,
+		notifyC:  make(chan struct{}),
+		spaceC:   make(chan struct{}),
 	}
 }
 
 // Empty checks if q is empty
-func (q *Queue) Empty() bool {
+func (q *Queue[T]) Empty() bool {
 	q.lock.Lock()
 	empty := q.count == 0
 	q.lock.Unlock()
@@ -31,36 +82,196 @@ func (q *Queue) Empty() bool {
 	return empty
 }
 
-// Put pus element into q at the last position
-func (q *Queue) Put(element interface{}) {
+// Len returns the number of the elements in q.
+func (q *Queue[T]) Len() int {
 	q.lock.Lock()
 	defer q.lock.Unlock()
 
-	if q.head == q.tail && q.count > 0 {
-		nodes := make([]interface{}, len(q.elements)+q.size)
-		copy(nodes, q.elements[q.head:])
-		copy(nodes[len(q.elements)-q.head:], q.elements[:q.head])
-		q.head = 0
-		q.tail = len(q.elements)
-		q.elements = nodes
+	return q.count
+}
+
+// Put pus element into q at the last position, it returns false if q is full.
+func (q *Queue[T]) Put(element T) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.fullLocked() {
+		return false
 	}
 
-	q.elements[q.tail] = element
-	q.tail = (q.tail + 1) % len(q.elements)
-	q.count++
+	q.putLocked(element)
+	return true
+}
+
+// PutCtx is like Put, but waits until there is room for the element if q is
+// full. It returns ctx.Err() if ctx is done before.
+func (q *Queue[T]) PutCtx(ctx context.Context, element T) error {
+	q.lock.Lock()
+	for q.fullLocked() {
+		q.putters++
+		spaceC := q.spaceC
+		q.lock.Unlock()
+
+		var err error
+		select {
+		case <-spaceC:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+
+		q.lock.Lock()
+		q.putters--
+		if err != nil {
+			q.lock.Unlock()
+			return err
+		}
+	}
+
+	q.putLocked(element)
+	q.lock.Unlock()
+	return nil
 }
 
 // Take takes the first element out of q if not empty
-func (q *Queue) Take() (interface{}, bool) {
+func (q *Queue[T]) Take() (T, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.count == 0 {
+		var zero T
+		return zero, false
+	}
+
+	return q.takeLocked(), true
+}
+
+// TakeCtx is like Take, but waits for an element to be put if q is empty.
+// It returns ctx.Err() if ctx is done before.
+func (q *Queue[T]) TakeCtx(ctx context.Context) (T, error) {
+	q.lock.Lock()
+	for q.count == 0 {
+		q.takers++
+		notifyC := q.notifyC
+		q.lock.Unlock()
+
+		var err error
+		select {
+		case <-notifyC:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+
+		q.lock.Lock()
+		q.takers--
+		if err != nil {
+			q.lock.Unlock()
+			var zero T
+			return zero, err
+		}
+	}
+
+	element := q.takeLocked()
+	q.lock.Unlock()
+	return element, nil
+}
+
+// Peek returns the first element of q without taking it out, if not empty.
+func (q *Queue[T]) Peek() (T, bool) {
 	q.lock.Lock()
 	defer q.lock.Unlock()
 
 	if q.count == 0 {
-		return nil, false
+		var zero T
+		return zero, false
 	}
 
+	return q.elements[q.head], true
+}
+
+// Drain takes at most max elements out of q in order, or all of them if max
+// is not positive. It returns nil if q is empty.
+func (q *Queue[T]) Drain(max int) []T {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	n := q.count
+	if max > 0 && max < n {
+		n = max
+	}
+	if n == 0 {
+		return nil
+	}
+
+	elements := make([]T, n)
+	for i := range elements {
+		elements[i] = q.takeLocked()
+	}
+
+	return elements
+}
+
+func (q *Queue[T]) fullLocked() bool {
+	return q.capacity > 0 && q.count >= q.capacity
+}
+
+// putLocked puts element into q, growing the backing ring if necessary,
+// must be called with q.lock held.
+func (q *Queue[T]) putLocked(element T) {
+	if q.head == q.tail && q.count > 0 {
+		n := len(q.elements) + q.size
+		if q.capacity > 0 && n > q.capacity {
+			n = q.capacity
+		}
+		q.resizeLocked(n)
+	}
+
+	q.elements[q.tail] = element
+	q.tail = (q.tail + 1) % len(q.elements)
+	q.count++
+
+	if q.takers > 0 {
+		close(q.notifyC)
+		q.notifyC = make(chan struct{})
+	}
+}
+
+// takeLocked takes the first element out of q, which must not be empty, and
+// shrinks the backing ring once mostly unused after a burst, must be called
+// with q.lock held.
+func (q *Queue[T]) takeLocked() T {
+	var zero T
 	element := q.elements[q.head]
+	// Release the reference held by the backing ring.
+	q.elements[q.head] = zero
 	q.head = (q.head + 1) % len(q.elements)
 	q.count--
-	return element, true
+
+	if n := len(q.elements) / 2; n >= q.size && q.count <= n/2 {
+		q.resizeLocked(n)
+	}
+
+	if q.putters > 0 {
+		close(q.spaceC)
+		q.spaceC = make(chan struct{})
+	}
+
+	return element
+}
+
+// resizeLocked moves the elements of q to a backing ring of size n, which
+// must be able to hold them, must be called with q.lock held.
+func (q *Queue[T]) resizeLocked(n int) {
+	nodes := make([]T, n)
+	if q.count > 0 {
+		if q.head < q.tail {
+			copy(nodes, q.elements[q.head:q.tail])
+		} else {
+			copied := copy(nodes, q.elements[q.head:])
+			copy(nodes[copied:], q.elements[:q.tail])
+		}
+	}
+
+	q.head = 0
+	q.tail = q.count % n
+	q.elements = nodes
 }
@@ -0,0 +1,137 @@
+package collection
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestQueueOrder(t *testing.T) {
+	q := NewQueue[int](2)
+	for i := 0; i < 10; i++ {
+		q.Put(i)
+	}
+	if first, _ := q.Peek(); first != 0 {
+		t.Fatalf("got %d first, want 0", first)
+	}
+	for i := 0; i < 10; i++ {
+		if got, ok := q.Take(); !ok || got != i {
+			t.Fatalf("got %d, %v, want %d", got, ok, i)
+		}
+	}
+	if !q.Empty() {
+		t.Fatal("the queue is not empty after taking everything")
+	}
+}
+
+func TestQueueCapacity(t *testing.T) {
+	q := NewQueue[int](8, WithCapacity(3))
+	for i := 0; i < 3; i++ {
+		if !q.Put(i) {
+			t.Fatalf("Put failed below the capacity, at %d", i)
+		}
+	}
+	if q.Put(3) {
+		t.Fatal("Put succeeded beyond the capacity")
+	}
+	if len(q.elements) > 3 {
+		t.Fatalf("the ring has grown to %d beyond the capacity", len(q.elements))
+	}
+
+	q.Take()
+	if !q.Put(3) {
+		t.Fatal("Put failed after taking an element out")
+	}
+}
+
+func TestQueuePutCtx(t *testing.T) {
+	q := NewQueue[int](1, WithCapacity(1))
+	q.Put(0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := q.PutCtx(ctx, 1); err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		q.Take()
+	}()
+	if err := q.PutCtx(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := q.Take(); got != 1 {
+		t.Fatalf("got %d, want 1", got)
+	}
+}
+
+func TestQueueTakeCtx(t *testing.T) {
+	q := NewQueue[int](1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errC := make(chan error)
+	go func() {
+		_, err := q.TakeCtx(ctx)
+		errC <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	if err := <-errC; err != context.Canceled {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		q.Put(42)
+	}()
+	got, err := q.TakeCtx(context.Background())
+	if err != nil || got != 42 {
+		t.Fatalf("got %d, %v, want 42", got, err)
+	}
+}
+
+func TestQueueDrain(t *testing.T) {
+	q := NewQueue[int](4)
+	if q.Drain(0) != nil {
+		t.Fatal("drained elements out of an empty queue")
+	}
+	for i := 0; i < 5; i++ {
+		q.Put(i)
+	}
+
+	if got := q.Drain(2); len(got) != 2 || got[0] != 0 || got[1] != 1 {
+		t.Fatalf("got %v, want [0 1]", got)
+	}
+	if got := q.Drain(0); len(got) != 3 || got[0] != 2 || got[2] != 4 {
+		t.Fatalf("got %v, want [2 3 4]", got)
+	}
+	if q.Len() != 0 {
+		t.Fatal("the queue is not empty after draining everything")
+	}
+}
+
+func TestQueueShrinks(t *testing.T) {
+	q := NewQueue[int](4)
+	for i := 0; i < 1000; i++ {
+		q.Put(i)
+	}
+	grown := len(q.elements)
+	for i := 0; i < 998; i++ {
+		if got, _ := q.Take(); got != i {
+			t.Fatalf("got %d, want %d", got, i)
+		}
+	}
+
+	if len(q.elements) >= grown/4 {
+		t.Fatalf("the ring of %d has not shrunk from %d", len(q.elements), grown)
+	}
+	if len(q.elements) < 4 {
+		t.Fatalf("the ring has shrunk to %d below the initial size", len(q.elements))
+	}
+	for _, want := range []int{998, 999} {
+		if got, _ := q.Take(); got != want {
+			t.Fatalf("got %d, want %d", got, want)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package collection
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+)
+
+type (
+	// PriorityQueue is a concurrency-safe priority queue, which takes out
+	// the elements in the order given by its comparator.
+	PriorityQueue[T any] struct {
+		lock sync.Mutex
+		heap handleHeap[T]
+		seq  uint64
+
+		// The number of the callers of Take waiting on notifyC, which is
+		// closed and replaced once an element is put.
+		takers  int
+		notifyC chan struct{}
+	}
+
+	// PriorityHandle is a handle to an element in a PriorityQueue, which is
+	// used to update or remove the element.
+	PriorityHandle[T any] struct {
+		value T
+		// seq is the order the element was put, which breaks the ties
+		// in a stable queue.
+		seq uint64
+		// index is the index of the element in the heap, -1 once taken
+		// out or removed.
+		index int
+		queue *PriorityQueue[T]
+	}
+
+	// PriorityQueueOption defines the method to customize a PriorityQueue.
+	PriorityQueueOption func(options *priorityQueueOptions)
+
+	priorityQueueOptions struct {
+		stable bool
+	}
+
+	handleHeap[T any] struct {
+		handles []*PriorityHandle[T]
+		less    func(a, b T) bool
+		stable  bool
+	}
+)
+
+// WithStableOrder customizes a PriorityQueue to take out the elements of
+// equal priorities in the order they were put. By default, their order is
+// unspecified.
+func WithStableOrder() PriorityQueueOption {
+	return func(options *priorityQueueOptions) {
+		options.stable = true
+	}
+}
+
+// NewPriorityQueue returns a PriorityQueue, which takes out the element a
+// before b if less(a, b) returns true.
+func NewPriorityQueue[T any](less func(a, b T) bool, opts ...PriorityQueueOption) *PriorityQueue[T] {
+	var options priorityQueueOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	return &PriorityQueue[T]{
+		heap: handleHeap[T]{
+			less:   less,
+			stable: options.stable,
+		},
+		notifyC: make(chan struct{}),
+	}
+}
+
+// Value returns the element of the handle.
+func (h *PriorityHandle[T]) Value() T {
+	h.queue.lock.Lock()
+	defer h.queue.lock.Unlock()
+
+	return h.value
+}
+
+// Len returns the number of the elements in q.
+func (q *PriorityQueue[T]) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return len(q.heap.handles)
+}
+
+// Peek returns the first element of q without taking it out, if not empty.
+func (q *PriorityQueue[T]) Peek() (T, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if len(q.heap.handles) == 0 {
+		var zero T
+		return zero, false
+	}
+
+	return q.heap.handles[0].value, true
+}
+
+// Put puts the element into q, and returns its handle.
+func (q *PriorityQueue[T]) Put(value T) *PriorityHandle[T] {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.seq++
+	h := &PriorityHandle[T]{
+		value: value,
+		seq:   q.seq,
+		queue: q,
+	}
+	heap.Push(&q.heap, h)
+
+	if q.takers > 0 {
+		close(q.notifyC)
+		q.notifyC = make(chan struct{})
+	}
+
+	return h
+}
+
+// Remove removes the element of the handle from q. It returns false if the
+// element has been taken out or removed already.
+func (q *PriorityQueue[T]) Remove(h *PriorityHandle[T]) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if !q.containsLocked(h) {
+		return false
+	}
+
+	heap.Remove(&q.heap, h.index)
+	return true
+}
+
+// Take waits for an element and takes the first one out of q. It returns
+// ctx.Err() if ctx is done before.
+func (q *PriorityQueue[T]) Take(ctx context.Context) (T, error) {
+	q.lock.Lock()
+	for len(q.heap.handles) == 0 {
+		q.takers++
+		notifyC := q.notifyC
+		q.lock.Unlock()
+
+		var err error
+		select {
+		case <-notifyC:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+
+		q.lock.Lock()
+		q.takers--
+		if err != nil {
+			q.lock.Unlock()
+			var zero T
+			return zero, err
+		}
+	}
+
+	h := heap.Pop(&q.heap).(*PriorityHandle[T])
+	q.lock.Unlock()
+	return h.value, nil
+}
+
+// TryTake takes the first element out of q if not empty.
+func (q *PriorityQueue[T]) TryTake() (T, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if len(q.heap.handles) == 0 {
+		var zero T
+		return zero, false
+	}
+
+	h := heap.Pop(&q.heap).(*PriorityHandle[T])
+	return h.value, true
+}
+
+// Update replaces the element of the handle with value, and moves it to
+// the position of its new priority. In a stable queue, it keeps the order
+// it was put among the equal priorities. It returns false if the element
+// has been taken out or removed already.
+func (q *PriorityQueue[T]) Update(h *PriorityHandle[T], value T) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if !q.containsLocked(h) {
+		return false
+	}
+
+	h.value = value
+	heap.Fix(&q.heap, h.index)
+	return true
+}
+
+func (q *PriorityQueue[T]) containsLocked(h *PriorityHandle[T]) bool {
+	return h != nil && h.queue == q && h.index >= 0 &&
+		h.index < len(q.heap.handles) && q.heap.handles[h.index] == h
+}
+
+func (h handleHeap[T]) Len() int {
+	return len(h.handles)
+}
+
+func (h handleHeap[T]) Less(i, j int) bool {
+	a, b := h.handles[i], h.handles[j]
+	if !h.stable {
+		return h.less(a.value, b.value)
+	}
+
+	if h.less(a.value, b.value) {
+		return true
+	}
+	if h.less(b.value, a.value) {
+		return false
+	}
+	return a.seq < b.seq
+}
+
+func (h handleHeap[T]) Swap(i, j int) {
+	h.handles[i], h.handles[j] = h.handles[j], h.handles[i]
+	h.handles[i].index = i
+	h.handles[j].index = j
+}
+
+func (h *handleHeap[T]) Push(x interface{}) {
+	handle := x.(*PriorityHandle[T])
+	handle.index = len(h.handles)
+	h.handles = append(h.handles, handle)
+}
+
+func (h *handleHeap[T]) Pop() interface{} {
+	n := len(h.handles)
+	handle := h.handles[n-1]
+	// Release the reference held by the backing array.
+	h.handles[n-1] = nil
+	h.handles = h.handles[:n-1]
+	handle.index = -1
+	return handle
+}
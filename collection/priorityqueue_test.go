@@ -0,0 +1,102 @@
+package collection
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type prioritized struct {
+	priority int
+	name     string
+}
+
+func lessPriority(a, b prioritized) bool {
+	return a.priority < b.priority
+}
+
+func TestPriorityQueueStableOrder(t *testing.T) {
+	q := NewPriorityQueue(lessPriority, WithStableOrder())
+	q.Put(prioritized{2, "c"})
+	q.Put(prioritized{1, "a"})
+	q.Put(prioritized{2, "d"})
+	q.Put(prioritized{1, "b"})
+	q.Put(prioritized{2, "e"})
+
+	for _, want := range []string{"a", "b", "c", "d", "e"} {
+		got, ok := q.TryTake()
+		if !ok || got.name != want {
+			t.Fatalf("got %v, %v, want %s", got, ok, want)
+		}
+	}
+	if _, ok := q.TryTake(); ok {
+		t.Fatal("took an element out of an empty queue")
+	}
+}
+
+func TestPriorityQueueHandles(t *testing.T) {
+	q := NewPriorityQueue(lessPriority, WithStableOrder())
+	a := q.Put(prioritized{1, "a"})
+	b := q.Put(prioritized{2, "b"})
+	c := q.Put(prioritized{3, "c"})
+
+	if !q.Update(c, prioritized{0, "c"}) {
+		t.Fatal("Update failed on a queued element")
+	}
+	if first, _ := q.Peek(); first.name != "c" {
+		t.Fatalf("got %s first, want c after its update", first.name)
+	}
+	if !q.Remove(a) || q.Remove(a) {
+		t.Fatal("Remove should succeed only once")
+	}
+	if q.Len() != 2 {
+		t.Fatalf("got %d elements, want 2", q.Len())
+	}
+
+	for _, want := range []string{"c", "b"} {
+		if got, _ := q.TryTake(); got.name != want {
+			t.Fatalf("got %s, want %s", got.name, want)
+		}
+	}
+	if q.Update(b, prioritized{0, "b"}) || q.Remove(b) {
+		t.Fatal("the handle of a taken element is still usable")
+	}
+
+	other := NewPriorityQueue(lessPriority)
+	h := other.Put(prioritized{1, "other"})
+	q.Put(prioritized{1, "mine"})
+	if q.Remove(h) {
+		t.Fatal("removed the element of another queue")
+	}
+}
+
+func TestPriorityQueueTake(t *testing.T) {
+	q := NewPriorityQueue(lessPriority)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := q.Take(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		q.Put(prioritized{1, "a"})
+	}()
+	got, err := q.Take(context.Background())
+	if err != nil || got.name != "a" {
+		t.Fatalf("got %v, %v, want a", got, err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	errC := make(chan error)
+	go func() {
+		_, err := q.Take(ctx)
+		errC <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	if err := <-errC; err != context.Canceled {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+}
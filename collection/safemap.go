@@ -12,67 +12,106 @@ const (
 // SafeMap provides a map alternative to avoid memory leak.
 // This implementation is not needed until issue below fixed.
 // https://github.com/golang/go/issues/20135
-type SafeMap struct {
+type SafeMap[K comparable, V any] struct {
 	lock        sync.RWMutex
 	deletionOld int
 	deletionNew int
-	dirtyOld    map[interface{}]interface{}
-	dirtyNew    map[interface{}]interface{}
+	dirtyOld    map[K]V
+	dirtyNew    map[K]V
 }
 
 // NewSafeMap returns a SafeMap.
-func NewSafeMap() *SafeMap {
-	return &SafeMap{
-		dirtyOld: make(map[interface{}]interface{}),
-		dirtyNew: make(map[interface{}]interface{}),
+func NewSafeMap[K comparable, V any]() *SafeMap[K, V] {
+	return &SafeMap[K, V]{
+		dirtyOld: make(map[K]V),
+		dirtyNew: make(map[K]V),
 	}
 }
 
-// Del deletes the value with the given key from m.
-func (m *SafeMap) Del(key interface{}) {
+// CompareAndSwap swaps the value with the given key for new if it is equal
+// to old, and returns true if swapped. Like sync.Map, it panics if the values
+// are not comparable.
+func (m *SafeMap[K, V]) CompareAndSwap(key K, old, new V) bool {
 	m.lock.Lock()
-	if _, ok := m.dirtyOld[key]; ok {
-		delete(m.dirtyOld, key)
-		m.deletionOld++
-	} else if _, ok := m.dirtyNew[key]; ok {
-		delete(m.dirtyNew, key)
-		m.deletionNew++
+	defer m.lock.Unlock()
+
+	val, ok := m.getLocked(key)
+	if !ok || any(val) != any(old) {
+		return false
 	}
-	if m.deletionOld >= maxDeletion && len(m.dirtyOld) < copyThreshold {
-		for k, v := range m.dirtyOld {
-			m.dirtyNew[k] = v
-		}
-		m.dirtyOld = m.dirtyNew
-		m.deletionOld = m.deletionNew
-		m.dirtyNew = make(map[interface{}]interface{})
-		m.deletionNew = 0
+
+	m.setLocked(key, new)
+	return true
+}
+
+// Compute computes the value with the given key by fn, which is called with
+// the current value and whether it exists, while m is locked. The returned
+// value is set if keep is true, otherwise the key is deleted. Compute returns
+// the resulting value and whether it exists.
+func (m *SafeMap[K, V]) Compute(key K, fn func(val V, ok bool) (newVal V, keep bool)) (V, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	val, ok := m.getLocked(key)
+	val, keep := fn(val, ok)
+	if keep {
+		m.setLocked(key, val)
+		return val, true
 	}
-	if m.deletionNew >= maxDeletion && len(m.dirtyNew) < copyThreshold {
-		for k, v := range m.dirtyNew {
-			m.dirtyOld[k] = v
-		}
-		m.dirtyNew = make(map[interface{}]interface{})
-		m.deletionNew = 0
+
+	if ok {
+		m.delLocked(key)
 	}
+	var zero V
+	return zero, false
+}
+
+// Del deletes the value with the given key from m.
+func (m *SafeMap[K, V]) Del(key K) {
+	m.lock.Lock()
+	m.delLocked(key)
 	m.lock.Unlock()
 }
 
 // Get gets the value with the given key from m.
-func (m *SafeMap) Get(key interface{}) (interface{}, bool) {
+func (m *SafeMap[K, V]) Get(key K) (V, bool) {
 	m.lock.RLock()
 	defer m.lock.RUnlock()
 
-	if val, ok := m.dirtyOld[key]; ok {
+	return m.getLocked(key)
+}
+
+// GetOrSet returns the existing value with the given key if present,
+// otherwise it sets and returns value. The loaded result is true if the
+// value was present.
+func (m *SafeMap[K, V]) GetOrSet(key K, value V) (actual V, loaded bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if val, ok := m.getLocked(key); ok {
 		return val, true
 	}
 
-	val, ok := m.dirtyNew[key]
+	m.setLocked(key, value)
+	return value, false
+}
+
+// LoadAndDelete deletes the value with the given key from m, and returns
+// it along with whether it was present.
+func (m *SafeMap[K, V]) LoadAndDelete(key K) (V, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	val, ok := m.getLocked(key)
+	if ok {
+		m.delLocked(key)
+	}
 	return val, ok
 }
 
 // Range calls f sequentially for each key and value present in the map.
 // If f returns false, range stops the iteration.
-func (m *SafeMap) Range(f func(key, val interface{}) bool) {
+func (m *SafeMap[K, V]) Range(f func(key K, val V) bool) {
 	m.lock.RLock()
 	defer m.lock.RUnlock()
 
@@ -89,8 +128,63 @@ func (m *SafeMap) Range(f func(key, val interface{}) bool) {
 }
 
 // Set sets the value into m with the given key.
-func (m *SafeMap) Set(key, value interface{}) {
+func (m *SafeMap[K, V]) Set(key K, value V) {
 	m.lock.Lock()
+	m.setLocked(key, value)
+	m.lock.Unlock()
+}
+
+// SetIfAbsent sets the value into m with the given key if not present,
+// and returns true if set.
+func (m *SafeMap[K, V]) SetIfAbsent(key K, value V) bool {
+	_, loaded := m.GetOrSet(key, value)
+	return !loaded
+}
+
+// Size returns the size of m.
+func (m *SafeMap[K, V]) Size() int {
+	m.lock.RLock()
+	size := len(m.dirtyOld) + len(m.dirtyNew)
+	m.lock.RUnlock()
+	return size
+}
+
+func (m *SafeMap[K, V]) delLocked(key K) {
+	if _, ok := m.dirtyOld[key]; ok {
+		delete(m.dirtyOld, key)
+		m.deletionOld++
+	} else if _, ok := m.dirtyNew[key]; ok {
+		delete(m.dirtyNew, key)
+		m.deletionNew++
+	}
+	if m.deletionOld >= maxDeletion && len(m.dirtyOld) < copyThreshold {
+		for k, v := range m.dirtyOld {
+			m.dirtyNew[k] = v
+		}
+		m.dirtyOld = m.dirtyNew
+		m.deletionOld = m.deletionNew
+		m.dirtyNew = make(map[K]V)
+		m.deletionNew = 0
+	}
+	if m.deletionNew >= maxDeletion && len(m.dirtyNew) < copyThreshold {
+		for k, v := range m.dirtyNew {
+			m.dirtyOld[k] = v
+		}
+		m.dirtyNew = make(map[K]V)
+		m.deletionNew = 0
+	}
+}
+
+func (m *SafeMap[K, V]) getLocked(key K) (V, bool) {
+	if val, ok := m.dirtyOld[key]; ok {
+		return val, true
+	}
+
+	val, ok := m.dirtyNew[key]
+	return val, ok
+}
+
+func (m *SafeMap[K, V]) setLocked(key K, value V) {
 	if m.deletionOld <= maxDeletion {
 		if _, ok := m.dirtyNew[key]; ok {
 			delete(m.dirtyNew, key)
@@ -104,13 +198,4 @@ func (m *SafeMap) Set(key, value interface{}) {
 		}
 		m.dirtyNew[key] = value
 	}
-	m.lock.Unlock()
-}
-
-// Size returns the size of m.
-func (m *SafeMap) Size() int {
-	m.lock.RLock()
-	size := len(m.dirtyOld) + len(m.dirtyNew)
-	m.lock.RUnlock()
-	return size
 }
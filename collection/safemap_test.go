@@ -0,0 +1,130 @@
+package collection
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestSafeMapGetOrSet(t *testing.T) {
+	m := NewSafeMap[string, int]()
+	if v, loaded := m.GetOrSet("a", 1); loaded || v != 1 {
+		t.Fatalf("got %d, %v, want 1, false", v, loaded)
+	}
+	if v, loaded := m.GetOrSet("a", 2); !loaded || v != 1 {
+		t.Fatalf("got %d, %v, want 1, true", v, loaded)
+	}
+
+	if !m.SetIfAbsent("b", 1) || m.SetIfAbsent("b", 2) {
+		t.Fatal("SetIfAbsent should succeed only once")
+	}
+	if v, _ := m.Get("b"); v != 1 {
+		t.Fatalf("got %d, want 1", v)
+	}
+}
+
+func TestSafeMapLoadAndDelete(t *testing.T) {
+	m := NewSafeMap[string, int]()
+	m.Set("a", 1)
+	if v, ok := m.LoadAndDelete("a"); !ok || v != 1 {
+		t.Fatalf("got %d, %v, want 1, true", v, ok)
+	}
+	if _, ok := m.LoadAndDelete("a"); ok {
+		t.Fatal("deleted a missing key")
+	}
+	if m.Size() != 0 {
+		t.Fatal("the map is not empty")
+	}
+}
+
+func TestSafeMapCompareAndSwap(t *testing.T) {
+	m := NewSafeMap[string, int]()
+	if m.CompareAndSwap("a", 0, 1) {
+		t.Fatal("swapped a missing key")
+	}
+
+	m.Set("a", 1)
+	if m.CompareAndSwap("a", 2, 3) {
+		t.Fatal("swapped a different value")
+	}
+	if !m.CompareAndSwap("a", 1, 2) {
+		t.Fatal("failed to swap an equal value")
+	}
+	if v, _ := m.Get("a"); v != 2 {
+		t.Fatalf("got %d, want 2", v)
+	}
+}
+
+func TestSafeMapCompute(t *testing.T) {
+	m := NewSafeMap[string, int]()
+	incr := func(val int, ok bool) (int, bool) {
+		return val + 1, true
+	}
+	if v, ok := m.Compute("a", incr); !ok || v != 1 {
+		t.Fatalf("got %d, %v, want 1, true", v, ok)
+	}
+	if v, ok := m.Compute("a", incr); !ok || v != 2 {
+		t.Fatalf("got %d, %v, want 2, true", v, ok)
+	}
+
+	v, ok := m.Compute("a", func(val int, ok bool) (int, bool) {
+		if !ok || val != 2 {
+			t.Fatalf("got %d, %v, want 2, true", val, ok)
+		}
+		return 0, false
+	})
+	if ok || v != 0 {
+		t.Fatalf("got %d, %v, want the key deleted", v, ok)
+	}
+	if _, ok := m.Get("a"); ok {
+		t.Fatal("the key is not deleted")
+	}
+}
+
+func TestSafeMapConcurrent(t *testing.T) {
+	const (
+		goroutines = 8
+		rounds     = 1000
+		keys       = 16
+	)
+
+	m := NewSafeMap[string, int]()
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < rounds; j++ {
+				key := strconv.Itoa(j % keys)
+				m.Compute(key, func(val int, ok bool) (int, bool) {
+					return val + 1, true
+				})
+				// Increments the counter of its own key by CompareAndSwap.
+				own := "own" + strconv.Itoa(i)
+				for {
+					val, _ := m.GetOrSet(own, 0)
+					if m.CompareAndSwap(own, val, val+1) {
+						break
+					}
+				}
+				m.SetIfAbsent("shared", i)
+				m.LoadAndDelete("shared")
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	total := 0
+	for i := 0; i < keys; i++ {
+		v, _ := m.Get(strconv.Itoa(i))
+		total += v
+	}
+	if total != goroutines*rounds {
+		t.Fatalf("got %d increments by Compute, want %d", total, goroutines*rounds)
+	}
+	for i := 0; i < goroutines; i++ {
+		if v, _ := m.Get("own" + strconv.Itoa(i)); v != rounds {
+			t.Fatalf("got %d increments by CompareAndSwap, want %d", v, rounds)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package collection
+
+import "errors"
+
+const (
+	defaultShardCount = 32
+
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+type (
+	// ShardedMap is a SafeMap split into several shards by the hash of the keys,
+	// each with its own lock and its own memory reclamation, so that the
+	// operations on different keys scale across the cores.
+	ShardedMap[K comparable, V any] struct {
+		shards []*SafeMap[K, V]
+		hash   func(key K) uint64
+	}
+
+	// ShardedMapOption defines the method to customize a ShardedMap.
+	ShardedMapOption func(options *shardedMapOptions)
+
+	shardedMapOptions struct {
+		shards int
+	}
+
+	// Integer is the constraint of the keys hashed by HashInt.
+	Integer interface {
+		~int | ~int8 | ~int16 | ~int32 | ~int64 |
+			~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr
+	}
+)
+
+// WithShardCount customizes a ShardedMap with the number of its shards.
+// The default number is 32.
+func WithShardCount(shards int) ShardedMapOption {
+	if shards <= 0 {
+		panic(errors.New("shards must be greater than 0"))
+	}
+
+	return func(options *shardedMapOptions) {
+		options.shards = shards
+	}
+}
+
+// NewShardedMap returns a ShardedMap, which places the keys into the shards
+// by the given hash function, e.g. HashString or HashInt.
+func NewShardedMap[K comparable, V any](hash func(key K) uint64, opts ...ShardedMapOption) *ShardedMap[K, V] {
+	if hash == nil {
+		panic(errors.New("hash must not be nil"))
+	}
+
+	options := shardedMapOptions{
+		shards: defaultShardCount,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	m := &ShardedMap[K, V]{
+		shards: make([]*SafeMap[K, V], options.shards),
+		hash:   hash,
+	}
+	for i := range m.shards {
+		m.shards[i] = NewSafeMap[K, V]()
+	}
+	return m
+}
+
+// HashString hashes a string key by FNV-1a.
+func HashString(key string) uint64 {
+	// Inlined to avoid allocating a hash.Hash64 for each key.
+	h := uint64(fnvOffset64)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= fnvPrime64
+	}
+	return h
+}
+
+// HashInt hashes an integer key by mixing its bits, so that the sequential
+// keys are spread over the shards.
+func HashInt[K Integer](key K) uint64 {
+	// The finalizer of SplitMix64.
+	x := uint64(key)
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// CompareAndSwap swaps the value with the given key for new if it is equal
+// to old, and returns true if swapped. Please see SafeMap.CompareAndSwap for the details.
+func (m *ShardedMap[K, V]) CompareAndSwap(key K, old, new V) bool {
+	return m.shard(key).CompareAndSwap(key, old, new)
+}
+
+// Compute computes the value with the given key by fn, while the shard of
+// the key is locked. Please see SafeMap.Compute for the details.
+func (m *ShardedMap[K, V]) Compute(key K, fn func(val V, ok bool) (newVal V, keep bool)) (V, bool) {
+	return m.shard(key).Compute(key, fn)
+}
+
+// Del deletes the value with the given key from m.
+func (m *ShardedMap[K, V]) Del(key K) {
+	m.shard(key).Del(key)
+}
+
+// Get gets the value with the given key from m.
+func (m *ShardedMap[K, V]) Get(key K) (V, bool) {
+	return m.shard(key).Get(key)
+}
+
+// GetOrSet returns the existing value with the given key if present,
+// otherwise it sets and returns value. The loaded result is true if the
+// value was present.
+func (m *ShardedMap[K, V]) GetOrSet(key K, value V) (actual V, loaded bool) {
+	return m.shard(key).GetOrSet(key, value)
+}
+
+// LoadAndDelete deletes the value with the given key from m, and returns
+// it along with whether it was present.
+func (m *ShardedMap[K, V]) LoadAndDelete(key K) (V, bool) {
+	return m.shard(key).LoadAndDelete(key)
+}
+
+// Range calls f sequentially for each key and value present in the map.
+// If f returns false, range stops the iteration. The shards are locked one
+// at a time, so Range is not a consistent snapshot of m.
+func (m *ShardedMap[K, V]) Range(f func(key K, val V) bool) {
+	for _, shard := range m.shards {
+		stopped := false
+		shard.Range(func(key K, val V) bool {
+			if !f(key, val) {
+				stopped = true
+				return false
+			}
+			return true
+		})
+		if stopped {
+			return
+		}
+	}
+}
+
+// Set sets the value into m with the given key.
+func (m *ShardedMap[K, V]) Set(key K, value V) {
+	m.shard(key).Set(key, value)
+}
+
+// SetIfAbsent sets the value into m with the given key if not present,
+// and returns true if set.
+func (m *ShardedMap[K, V]) SetIfAbsent(key K, value V) bool {
+	return m.shard(key).SetIfAbsent(key, value)
+}
+
+// Size returns the size of m.
+func (m *ShardedMap[K, V]) Size() int {
+	var size int
+	for _, shard := range m.shards {
+		size += shard.Size()
+	}
+	return size
+}
+
+// shard returns the shard of the given key.
+func (m *ShardedMap[K, V]) shard(key K) *SafeMap[K, V] {
+	if len(m.shards) == 1 {
+		return m.shards[0]
+	}
+
+	return m.shards[m.hash(key)%uint64(len(m.shards))]
+}
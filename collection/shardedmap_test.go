@@ -0,0 +1,96 @@
+package collection
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+const benchmarkKeys = 1 << 12
+
+func TestNewShardedMapRejectsNilHash(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("NewShardedMap accepted a nil hash")
+		}
+	}()
+	NewShardedMap[string, int](nil)
+}
+
+func TestShardedMap(t *testing.T) {
+	m := NewShardedMap[string, int](HashString, WithShardCount(7))
+	for i := 0; i < 1000; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+	if size := m.Size(); size != 1000 {
+		t.Fatalf("got size %d, want 1000", size)
+	}
+	if v, ok := m.Get("42"); !ok || v != 42 {
+		t.Fatalf("got %d, %v, want 42, true", v, ok)
+	}
+	if m.SetIfAbsent("42", 0) {
+		t.Fatal("SetIfAbsent replaced an existing value")
+	}
+
+	var n int
+	m.Range(func(key string, val int) bool {
+		n++
+		return n < 10
+	})
+	if n != 10 {
+		t.Fatalf("Range visited %d entries after stopping at 10", n)
+	}
+}
+
+func benchmarkKeyStrings() []string {
+	keys := make([]string, benchmarkKeys)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	return keys
+}
+
+// benchmarkMap runs a mix of 90% reads and 10% writes in parallel.
+func benchmarkMap(b *testing.B, get func(key string), set func(key string, val int)) {
+	keys := benchmarkKeyStrings()
+	for i, key := range keys {
+		set(key, i)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			key := keys[i%benchmarkKeys]
+			if i%10 == 0 {
+				set(key, i)
+			} else {
+				get(key)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkShardedMap(b *testing.B) {
+	m := NewShardedMap[string, int](HashString)
+	benchmarkMap(b, func(key string) {
+		m.Get(key)
+	}, m.Set)
+}
+
+func BenchmarkSafeMap(b *testing.B) {
+	m := NewSafeMap[string, int]()
+	benchmarkMap(b, func(key string) {
+		m.Get(key)
+	}, m.Set)
+}
+
+func BenchmarkSyncMap(b *testing.B) {
+	var m sync.Map
+	benchmarkMap(b, func(key string) {
+		m.Load(key)
+	}, func(key string, val int) {
+		m.Store(key, val)
+	})
+}
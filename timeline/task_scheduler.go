@@ -1,7 +1,6 @@
 package timeline
 
 import (
-	"sync"
 	"time"
 
 	"github.com/shanluzhineng/threadingx/collection"
@@ -13,19 +12,45 @@ import (
 
 type timeIntervalScheduler struct {
 	interval time.Duration
+}
+
+func (s *timeIntervalScheduler) Next(prev time.Time) time.Time {
+	return prev.Add(s.interval)
+}
+
+// 按照固定间隔执行，不受系统时钟跳变的影响
+func (s *timeIntervalScheduler) Relative() bool {
+	return true
+}
+
+// 可以停止的调度器，停止后不再返回下一次执行的时间
+type stoppableScheduler struct {
+	scheduler timingwheel.Scheduler
 
 	stopped bool
 }
 
-func (s *timeIntervalScheduler) Next(prev time.Time) time.Time {
+func newStoppableScheduler(scheduler timingwheel.Scheduler) *stoppableScheduler {
+	return &stoppableScheduler{
+		scheduler: scheduler,
+	}
+}
+
+func (s *stoppableScheduler) Next(prev time.Time) time.Time {
 	if s.stopped {
 		//已经停止
 		return time.Time{}
 	}
-	return prev.Add(s.interval)
+	return s.scheduler.Next(prev)
+}
+
+// 与被包装的调度器保持一致
+func (s *stoppableScheduler) Relative() bool {
+	r, ok := s.scheduler.(timingwheel.RelativeScheduler)
+	return ok && r.Relative()
 }
 
-func (s *timeIntervalScheduler) stop() {
+func (s *stoppableScheduler) stop() {
 	s.stopped = true
 }
 
@@ -51,6 +76,11 @@ type ITaskScheduler interface {
 	//返回用于此任务的调度key
 	SchedulerFuncOneByOne(interval time.Duration, taskItem *TaskItem, callback func(*TaskItem) error, completeOpts ...func(ITaskSchedulerObserver)) ITaskSchedulerObserver
 
+	// 调度一个函数，此函数按照scheduler给出的执行计划执行,这个定时器的回调是可能会存在着并行执行的
+	// scheduler可以使用timingwheel中的调度器,如timingwheel.ParseCron,timingwheel.Backoff等
+	//返回用于此任务的调度key
+	SchedulerFuncBy(scheduler timingwheel.Scheduler, taskItem *TaskItem, callback func(*TaskItem) error, completeOpts ...func(ITaskSchedulerObserver)) ITaskSchedulerObserver
+
 	//停止指定的调度项,如果key不存在，则返回false
 	StopScheduler(key string) bool
 }
@@ -70,7 +100,7 @@ type ITaskSchedulerObserver interface {
 type taskSchedulerObserver struct {
 	timer     *timingwheel.Timer
 	host      *taskScheduler
-	scheduler *timeIntervalScheduler
+	scheduler *stoppableScheduler
 
 	completeCallbackList []func(ITaskSchedulerObserver)
 	taskItem             *TaskItem
@@ -141,15 +171,14 @@ func (o *taskSchedulerObserver) notifyCompleted() {
 type taskScheduler struct {
 	timingWheel *timingwheel.TimingWheel
 
-	rwLock                sync.RWMutex
-	schedulerObserverList *collection.SafeMap
+	schedulerObserverList *collection.ShardedMap[string, *taskSchedulerObserver]
 }
 
 var _ ITaskScheduler = (*taskScheduler)(nil)
 
 func NewTaskScheduler() ITaskScheduler {
 	scheduler := &taskScheduler{
-		schedulerObserverList: collection.NewSafeMap(),
+		schedulerObserverList: collection.NewShardedMap[string, *taskSchedulerObserver](collection.HashString),
 	}
 	scheduler.timingWheel = timingwheel.NewTimingWheel(time.Millisecond, slots)
 	scheduler.timingWheel.Start()
@@ -160,7 +189,7 @@ func NewTaskScheduler() ITaskScheduler {
 
 func (s *taskScheduler) _afterFunc(interval time.Duration, taskItem *TaskItem, callback func(*TaskItem) error, observer *taskSchedulerObserver) {
 	if len(taskItem.key) <= 0 {
-		taskItem.key = s.newKey()
+		taskItem.key = s.newKey(observer)
 	}
 	t := s.timingWheel.AfterFunc(interval, func() {
 		defer func() {
@@ -202,17 +231,29 @@ func (s *taskScheduler) SchedulerFunc(interval time.Duration,
 	callback func(*TaskItem) error,
 	completeOpts ...func(ITaskSchedulerObserver)) ITaskSchedulerObserver {
 
-	if len(taskItem.key) <= 0 {
-		taskItem.key = s.newKey()
-	}
-	scheduler := &timeIntervalScheduler{
+	return s.SchedulerFuncBy(&timeIntervalScheduler{
 		interval: interval,
-	}
+	}, taskItem, callback, completeOpts...)
+}
+
+// 调度一个函数，此函数按照scheduler给出的执行计划执行
+// 返回用于此任务的调度key
+func (s *taskScheduler) SchedulerFuncBy(plan timingwheel.Scheduler,
+	taskItem *TaskItem,
+	callback func(*TaskItem) error,
+	completeOpts ...func(ITaskSchedulerObserver)) ITaskSchedulerObserver {
+
+	scheduler := newStoppableScheduler(plan)
 	observer := newTaskSchedulerObserver(s)
 	observer.taskItem = taskItem
 	observer.scheduler = scheduler
 	observer.AddCompleteCallbacks(completeOpts...)
 
+	generated := len(taskItem.key) <= 0
+	if generated {
+		taskItem.key = s.newKey(observer)
+	}
+
 	t := s.timingWheel.ScheduleFuncWith(scheduler, taskItem.key, func() {
 		//触发回调
 		threading.SafeCallFunc(func() {
@@ -224,6 +265,10 @@ func (s *taskScheduler) SchedulerFunc(interval time.Duration,
 		threading.SafeCallFunc(observer.notifyCompleted)
 	})
 	if t == nil {
+		if generated {
+			//释放预留的key
+			s.schedulerObserverList.Del(taskItem.key)
+		}
 		return nil
 	}
 	s.schedulerObserverList.Set(taskItem.key, observer)
@@ -235,16 +280,16 @@ func (s *taskScheduler) SchedulerFuncOneByOne(interval time.Duration,
 	callback func(*TaskItem) error,
 	completeOpts ...func(ITaskSchedulerObserver)) ITaskSchedulerObserver {
 
-	if len(taskItem.key) <= 0 {
-		taskItem.key = s.newKey()
-	}
-	scheduler := &timeIntervalScheduler{
+	scheduler := newStoppableScheduler(&timeIntervalScheduler{
 		interval: interval,
-	}
+	})
 	observer := newTaskSchedulerObserver(s)
 	observer.taskItem = taskItem
 	observer.scheduler = scheduler
 	observer.AddCompleteCallbacks(completeOpts...)
+	if len(taskItem.key) <= 0 {
+		taskItem.key = s.newKey(observer)
+	}
 
 	compCallback := func(to ITaskSchedulerObserver) {
 		//check IsStopped
@@ -262,25 +307,21 @@ func (s *taskScheduler) SchedulerFuncOneByOne(interval time.Duration,
 
 // 移除指定的调度项,如果key不存在，则返回false
 func (s *taskScheduler) StopScheduler(key string) bool {
-	observerValue, ok := s.schedulerObserverList.Get(key)
+	observer, ok := s.schedulerObserverList.Get(key)
 	if !ok {
 		return false
 	}
-	observer := observerValue.(*taskSchedulerObserver)
 	observer.Stop()
 	return true
 }
 
 // #endregion
 
-func (s *taskScheduler) newKey() string {
-	key := stringx.Randn(10)
-
-	s.rwLock.Lock()
-	defer s.rwLock.Unlock()
+// 生成一个未被使用的key,并为observer预留
+func (s *taskScheduler) newKey(observer *taskSchedulerObserver) string {
 	for {
-		_, ok := s.schedulerObserverList.Get(key)
-		if !ok {
+		key := stringx.Randn(10)
+		if s.schedulerObserverList.SetIfAbsent(key, observer) {
 			return key
 		}
 	}
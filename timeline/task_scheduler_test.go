@@ -0,0 +1,27 @@
+package timeline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shanluzhineng/threadingx/timingwheel"
+)
+
+func TestSchedulersAreRelative(t *testing.T) {
+	tests := []struct {
+		name      string
+		scheduler timingwheel.Scheduler
+		relative  bool
+	}{
+		{"interval", &timeIntervalScheduler{interval: time.Second}, true},
+		{"stoppable interval", newStoppableScheduler(&timeIntervalScheduler{interval: time.Second}), true},
+		{"stoppable every", newStoppableScheduler(timingwheel.Every(time.Second)), true},
+		{"stoppable cron", newStoppableScheduler(timingwheel.MustParseCron("* * * * *")), false},
+	}
+	for _, tt := range tests {
+		r, ok := tt.scheduler.(timingwheel.RelativeScheduler)
+		if got := ok && r.Relative(); got != tt.relative {
+			t.Errorf("%s: got relative %v, want %v", tt.name, got, tt.relative)
+		}
+	}
+}
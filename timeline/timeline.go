@@ -44,7 +44,7 @@ type timeline struct {
 	taskScheduler ITaskScheduler
 
 	//只执行一次的observer队列
-	registedOneTimeObserverQueue *collection.Queue
+	registedOneTimeObserverQueue *collection.Queue[ITimelineObserver]
 	//一直订阅的observer列表
 	registedObserverList []ITimelineObserver
 	rwLock               sync.RWMutex
@@ -54,7 +54,7 @@ type timeline struct {
 
 func newDefaultTimeline() ITimeline {
 	timelineService := &timeline{
-		registedOneTimeObserverQueue: collection.NewQueue(5),
+		registedOneTimeObserverQueue: collection.NewQueue[ITimelineObserver](5),
 		registedObserverList:         make([]ITimelineObserver, 0),
 		isChanged:                    false,
 	}
@@ -0,0 +1,246 @@
+package timex
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	// Clock abstracts the passing of time, so that the code depending on it
+	// can be driven by a virtual clock in tests.
+	Clock interface {
+		// Now returns the current time.
+		Now() time.Time
+		// Elapsed returns the monotonic time elapsed since the clock was created,
+		// which is not affected by the steps of the wall clock.
+		Elapsed() time.Duration
+		// After waits for the duration to elapse and then sends the current time
+		// on the returned channel.
+		After(d time.Duration) <-chan time.Time
+		// NewTimer creates a new Timer that will send the current time on its
+		// channel after at least duration d.
+		NewTimer(d time.Duration) Timer
+	}
+
+	// Timer interface wraps the methods of time.Timer.
+	Timer interface {
+		Chan() <-chan time.Time
+		Reset(d time.Duration) bool
+		Stop() bool
+	}
+
+	// FakeClock is a manually-advanced virtual clock used for unit testing.
+	// Timers created from a FakeClock only fire when the clock is advanced
+	// past their deadlines, without any wall-clock sleeping.
+	FakeClock interface {
+		Clock
+		// Advance moves the clock forward by d, firing all the timers that are due.
+		Advance(d time.Duration)
+		// Set moves the wall clock to t. Moving it forward advances the elapsed
+		// time as well, firing all the timers that are due, while moving it
+		// backward only steps the wall clock like Jump, without firing any timer.
+		Set(t time.Time)
+		// Jump steps the wall clock by d, forward or backward, without moving
+		// the elapsed time or firing any timer, like an NTP step does.
+		Jump(d time.Duration)
+		// Waiters returns the number of timers that have not fired yet.
+		Waiters() int
+	}
+
+	realClock struct {
+		start time.Time
+	}
+
+	realTimer struct {
+		*time.Timer
+	}
+
+	fakeClock struct {
+		lock    sync.Mutex
+		now     time.Time
+		elapsed time.Duration
+		timers  []*fakeTimer
+	}
+
+	fakeTimer struct {
+		clock *fakeClock
+		c     chan time.Time
+		// the elapsed time of the clock at which the timer fires.
+		deadline time.Duration
+	}
+)
+
+// NewClock returns a Clock backed by the system time.
+func NewClock() Clock {
+	return realClock{
+		start: time.Now(),
+	}
+}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (rc realClock) Elapsed() time.Duration {
+	// start carries a monotonic clock reading, so does the subtraction.
+	return time.Since(rc.start)
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return &realTimer{
+		Timer: time.NewTimer(d),
+	}
+}
+
+func (rt *realTimer) Chan() <-chan time.Time {
+	return rt.C
+}
+
+// NewFakeClock returns a FakeClock starting at the given time.
+func NewFakeClock(start time.Time) FakeClock {
+	return &fakeClock{
+		now: start,
+	}
+}
+
+func (fc *fakeClock) Now() time.Time {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	return fc.now
+}
+
+func (fc *fakeClock) Elapsed() time.Duration {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	return fc.elapsed
+}
+
+func (fc *fakeClock) After(d time.Duration) <-chan time.Time {
+	return fc.NewTimer(d).Chan()
+}
+
+func (fc *fakeClock) NewTimer(d time.Duration) Timer {
+	ft := &fakeTimer{
+		clock: fc,
+		c:     make(chan time.Time, 1),
+	}
+
+	fc.lock.Lock()
+	fc.schedule(ft, d)
+	fc.lock.Unlock()
+
+	return ft
+}
+
+func (fc *fakeClock) Advance(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	// compute and apply the new time under a single lock, so that the
+	// concurrent calls do not lose any advance.
+	fc.setLocked(fc.now.Add(d))
+}
+
+func (fc *fakeClock) Set(t time.Time) {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	fc.setLocked(t)
+}
+
+// setLocked moves the clock to t, must be called with fc.lock held.
+func (fc *fakeClock) setLocked(t time.Time) {
+	if !t.After(fc.now) {
+		// step the wall clock backward, the elapsed time never goes back.
+		fc.now = t
+		return
+	}
+
+	fc.elapsed += t.Sub(fc.now)
+	fc.now = t
+
+	// fire the due timers in the order of their deadlines
+	sort.SliceStable(fc.timers, func(i, j int) bool {
+		return fc.timers[i].deadline < fc.timers[j].deadline
+	})
+	n := 0
+	for _, ft := range fc.timers {
+		if ft.deadline > fc.elapsed {
+			break
+		}
+		ft.fire(fc.now)
+		n++
+	}
+	fc.timers = fc.timers[n:]
+}
+
+func (fc *fakeClock) Jump(d time.Duration) {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	fc.now = fc.now.Add(d)
+}
+
+func (fc *fakeClock) Waiters() int {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	return len(fc.timers)
+}
+
+// remove removes ft from the pending timers, must be called with fc.lock held.
+func (fc *fakeClock) remove(ft *fakeTimer) bool {
+	for i, t := range fc.timers {
+		if t == ft {
+			fc.timers = append(fc.timers[:i], fc.timers[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// schedule arms ft to fire after d, must be called with fc.lock held.
+func (fc *fakeClock) schedule(ft *fakeTimer, d time.Duration) {
+	ft.deadline = fc.elapsed + d
+	if d <= 0 {
+		ft.fire(fc.now)
+		return
+	}
+
+	fc.timers = append(fc.timers, ft)
+}
+
+func (ft *fakeTimer) Chan() <-chan time.Time {
+	return ft.c
+}
+
+func (ft *fakeTimer) Reset(d time.Duration) bool {
+	ft.clock.lock.Lock()
+	defer ft.clock.lock.Unlock()
+
+	active := ft.clock.remove(ft)
+	ft.clock.schedule(ft, d)
+	return active
+}
+
+func (ft *fakeTimer) Stop() bool {
+	ft.clock.lock.Lock()
+	defer ft.clock.lock.Unlock()
+	return ft.clock.remove(ft)
+}
+
+func (ft *fakeTimer) fire(now time.Time) {
+	// like the runtime timers, never block on a full channel
+	select {
+	case ft.c <- now:
+	default:
+	}
+}
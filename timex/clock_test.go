@@ -0,0 +1,55 @@
+package timex
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFakeClockConcurrentAdvance(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clock := NewFakeClock(start)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				clock.Advance(time.Millisecond)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := clock.Now().Sub(start); got != 800*time.Millisecond {
+		t.Fatalf("advanced by %v, want 800ms", got)
+	}
+	if got := clock.Elapsed(); got != 800*time.Millisecond {
+		t.Fatalf("got %v elapsed, want 800ms", got)
+	}
+}
+
+func TestFakeClockSet(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clock := NewFakeClock(start)
+	timer := clock.NewTimer(time.Second)
+
+	clock.Set(start.Add(-time.Hour))
+	if !clock.Now().Equal(start.Add(-time.Hour)) {
+		t.Fatalf("got %v, want the clock moved backward", clock.Now())
+	}
+	if clock.Elapsed() != 0 || clock.Waiters() != 1 {
+		t.Fatal("moving backward changed the elapsed time or fired a timer")
+	}
+
+	clock.Set(start.Add(-time.Hour + time.Second))
+	if clock.Elapsed() != time.Second {
+		t.Fatalf("got %v elapsed, want 1s", clock.Elapsed())
+	}
+	select {
+	case <-timer.Chan():
+	default:
+		t.Fatal("the due timer did not fire")
+	}
+}
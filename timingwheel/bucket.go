@@ -1,7 +1,7 @@
 package timingwheel
 
 import (
-	"container/list"
+	"container/heap"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -13,17 +13,67 @@ import (
 type Timer struct {
 	//用来标识的key
 	key        string
-	expiration int64 // in milliseconds
+	expiration int64 // in the time unit of the timing wheel, on the monotonic clock
 	task       func()
 
+	// The wall-clock time the timer is scheduled at, zero if the timer is
+	// scheduled after a duration. Guarded by mu.
+	wall time.Time
+	// Whether the timer follows the wall clock, so that it is rebased on
+	// the wall-clock jumps. Guarded by mu.
+	absolute bool
+
 	// The bucket that holds the list to which this timer's element belongs.
 	//
 	// NOTE: This field may be updated and read concurrently,
 	// through Timer.Stop() and Bucket.Flush().
 	b unsafe.Pointer // type: *bucket
 
-	// The timer's element.
-	element *list.Element
+	// The neighbours of the timer in the list of its bucket.
+	prev, next *Timer
+	// The index of the timer in the heap of the far bucket.
+	index int
+
+	// The timing wheel the timer belongs to.
+	tw *TimingWheel
+	// Whether the timer is scheduled by ScheduleFunc.
+	recurring bool
+	// Whether the timer is never exposed to the callers, so that it can be
+	// put back into timerPool once its task has been executed.
+	pooled bool
+	// The number of the holders of a pooled timer, which is put back into
+	// timerPool once the last one releases it.
+	refs int32
+	// Whether the timer has been stopped, which prevents a recurring timer
+	// from being rescheduled. Guarded by mu.
+	cancelled bool
+	// Called each time Stop is called, nil if none. Guarded by mu.
+	onStop func()
+	// mu serializes the operations that stop and re-add the timer.
+	mu sync.Mutex
+}
+
+// timerPool holds the timers of PostFunc for reuse.
+var timerPool = sync.Pool{
+	New: func() interface{} {
+		return &Timer{
+			pooled: true,
+		}
+	},
+}
+
+// releaseTimer puts the pooled timer t back into timerPool once its last
+// holder releases it, nothing else refers to t by then.
+func releaseTimer(t *Timer) {
+	if atomic.AddInt32(&t.refs, -1) > 0 {
+		return
+	}
+
+	t.refs = 0
+	t.expiration = 0
+	t.task = nil
+	t.tw = nil
+	timerPool.Put(t)
 }
 
 func (t *Timer) getBucket() *bucket {
@@ -40,7 +90,101 @@ func (t *Timer) setBucket(b *bucket) {
 // If the timer t has already expired and the t.task has been started in its own
 // goroutine; Stop does not wait for t.task to complete before returning. If the caller
 // needs to know whether t.task is completed, it must coordinate with t.task explicitly.
+//
+// For a timer scheduled by ScheduleFunc, Stop also prevents the timer from
+// being rescheduled by a task that has already been started.
 func (t *Timer) Stop() bool {
+	t.mu.Lock()
+	t.cancelled = true
+	stopped := t.stop()
+	key := t.key
+	onStop := t.onStop
+	t.mu.Unlock()
+
+	if onStop != nil {
+		onStop()
+	}
+	if stopped {
+		t.tw.registry.remove(t, key)
+		t.tw.stopped(t)
+	}
+	return stopped
+}
+
+// Reset changes the timer to expire after duration d, keeping its key and task.
+// It returns true if the timer had been active, false if the timer had
+// expired or been stopped.
+//
+// If the timer t has already expired, Reset schedules t.task to run again,
+// and adds t back to the key registry of the timing wheel. If the key is
+// taken by another pending timer under the DuplicateReject policy, t is not
+// scheduled again.
+func (t *Timer) Reset(d time.Duration) bool {
+	return t.reset(t.tw.after(d), time.Time{})
+}
+
+// ResetAt is like Reset but changes the timer to expire at the given time.
+// Unlike Reset, the timer follows the wall clock, even if it jumps.
+func (t *Timer) ResetAt(expiration time.Time) bool {
+	return t.reset(t.tw.wallToUnits(expiration), expiration.UTC())
+}
+
+// reset changes the timer to expire at expiration, in the time unit of the
+// timing wheel. The wall is the wall-clock expiration if the timer follows
+// the wall clock, or zero.
+func (t *Timer) reset(expiration int64, wall time.Time) bool {
+	t.mu.Lock()
+
+	active := t.stop()
+	var replaced []*Timer
+	if !active {
+		// Index t before adding it back, so that the index is not left
+		// behind if t fires right away.
+		var ok bool
+		if ok, replaced = t.tw.registry.index(t, t.key); !ok {
+			// The key is taken by another pending timer.
+			t.mu.Unlock()
+			return false
+		}
+	}
+	t.cancelled = false
+	t.expiration = expiration
+	t.wall = wall
+	t.absolute = !wall.IsZero()
+	exp := t.expiration
+	added := t.tw.add(t)
+	t.mu.Unlock()
+
+	stopTimers(replaced)
+	if !added {
+		// Already expired, run the task without holding t.mu.
+		t.tw.runOrDrop(t, exp)
+	}
+	return active
+}
+
+// rebase re-adds the timer according to its wall-clock expiration if it
+// follows the wall clock, after the wall clock has jumped.
+func (t *Timer) rebase() {
+	t.mu.Lock()
+	if !t.absolute || !t.stop() {
+		// t follows the monotonic clock, or is not pending.
+		t.mu.Unlock()
+		return
+	}
+
+	t.expiration = t.tw.wallToUnits(t.wall)
+	exp := t.expiration
+	added := t.tw.add(t)
+	t.mu.Unlock()
+
+	if !added {
+		// Already expired, run the task without holding t.mu.
+		t.tw.runOrDrop(t, exp)
+	}
+}
+
+func (t *Timer) stop() bool {
 	stopped := false
 	for b := t.getBucket(); b != nil; b = t.getBucket() {
 		// If b.Remove is called just after the timing wheel's goroutine has:
@@ -56,18 +200,53 @@ func (t *Timer) Stop() bool {
 }
 
 // 设置对应的key
+// 如果定时器仍在时间轮中，则同时更新时间轮的key索引
+// 若新的key被DuplicateReject策略拒绝，则保留原来的key
 func (t *Timer) SetKey(key string) *Timer {
+	t.mu.Lock()
+	var replaced []*Timer
+	alive := t.recurring || t.getBucket() != nil
+	if t.tw != nil && alive && t.key != key {
+		// 持有t.mu更新索引，避免与定时器触发时的索引清理交错
+		var ok bool
+		if ok, replaced = t.tw.registry.index(t, key); !ok {
+			t.mu.Unlock()
+			return t
+		}
+		t.tw.registry.remove(t, t.key)
+	}
 	t.key = key
+	t.mu.Unlock()
+
+	stopTimers(replaced)
 	return t
 }
 
 // 获取对应的key
 func (t *Timer) GetKey() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	return t.key
 }
 
+// GetExpiration returns the Unix time the timer expires at, in the time unit
+// of the timing wheel, which is 1ms by default.
+//
+// Deprecated: Use ExpirationTime, which does not depend on the time unit.
 func (t *Timer) GetExpiration() time.Duration {
-	return time.Duration(t.expiration)
+	return time.Duration(t.ExpirationTime().UnixNano() / int64(t.tw.unit))
+}
+
+// ExpirationTime returns the wall-clock time the timer expires at. For a timer
+// following the monotonic clock, it is estimated from the current wall clock.
+func (t *Timer) ExpirationTime() time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.absolute {
+		return t.wall
+	}
+	return t.tw.unitsToWall(t.expiration)
 }
 
 type bucket struct {
@@ -79,14 +258,33 @@ type bucket struct {
 	// and https://go101.org/article/memory-layout.html.
 	expiration int64
 
-	mu     sync.Mutex
-	timers *list.List
+	mu sync.Mutex
+	// The timers of the bucket, linked through Timer.prev and Timer.next,
+	// so that adding a timer does not allocate.
+	head, tail *Timer
+	size       int
+	// The number of the timers in the wheel of the bucket, which is read
+	// without b.mu, nil for the far bucket.
+	wheelPending *int32
+
+	// Whether the bucket holds the timers beyond the horizon of the timing
+	// wheel, which are kept in timers ordered by their expirations instead.
+	far    bool
+	timers timerHeap
+}
+
+func newBucket(wheelPending *int32) *bucket {
+	return &bucket{
+		expiration:   -1,
+		wheelPending: wheelPending,
+	}
 }
 
-func newBucket() *bucket {
+// newFarBucket creates a bucket that keeps its timers ordered by their expirations.
+func newFarBucket() *bucket {
 	return &bucket{
-		timers:     list.New(),
 		expiration: -1,
+		far:        true,
 	}
 }
 
@@ -98,14 +296,77 @@ func (b *bucket) SetExpiration(expiration int64) bool {
 	return atomic.SwapInt64(&b.expiration, expiration) != expiration
 }
 
+// Len returns the number of the timers in b.
+func (b *bucket) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.size
+}
+
 func (b *bucket) Add(t *Timer) {
 	b.mu.Lock()
+	b.link(t)
+	b.mu.Unlock()
+}
 
-	e := b.timers.PushBack(t)
+// link links t into b, must be called with b.mu held.
+func (b *bucket) link(t *Timer) {
+	b.size++
+	if b.wheelPending != nil {
+		atomic.AddInt32(b.wheelPending, 1)
+	}
 	t.setBucket(b)
-	t.element = e
+	if b.far {
+		heap.Push(&b.timers, t)
+		return
+	}
 
-	b.mu.Unlock()
+	t.prev = b.tail
+	t.next = nil
+	if b.tail == nil {
+		b.head = t
+	} else {
+		b.tail.next = t
+	}
+	b.tail = t
+}
+
+// first returns the first timer of b, which is the earliest one if b is far,
+// must be called with b.mu held.
+func (b *bucket) first() *Timer {
+	if b.far {
+		if len(b.timers) == 0 {
+			return nil
+		}
+		return b.timers[0]
+	}
+
+	return b.head
+}
+
+// unlink unlinks t from b, must be called with b.mu held.
+func (b *bucket) unlink(t *Timer) {
+	b.size--
+	if b.wheelPending != nil {
+		atomic.AddInt32(b.wheelPending, -1)
+	}
+	if b.far {
+		heap.Remove(&b.timers, t.index)
+		return
+	}
+
+	if t.prev == nil {
+		b.head = t.next
+	} else {
+		t.prev.next = t.next
+	}
+	if t.next == nil {
+		b.tail = t.prev
+	} else {
+		t.next.prev = t.prev
+	}
+	t.prev = nil
+	t.next = nil
 }
 
 func (b *bucket) remove(t *Timer) bool {
@@ -117,12 +378,26 @@ func (b *bucket) remove(t *Timer) bool {
 		// In either case, the returned value does not equal to b.
 		return false
 	}
-	b.timers.Remove(t.element)
+	b.unlink(t)
 	t.setBucket(nil)
-	t.element = nil
 	return true
 }
 
+// AppendTo appends the timers of b to timers, and returns the extended slice.
+func (b *bucket) AppendTo(timers []*Timer) []*Timer {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.far {
+		return append(timers, b.timers...)
+	}
+
+	for t := b.head; t != nil; t = t.next {
+		timers = append(timers, t)
+	}
+	return timers
+}
+
 func (b *bucket) Remove(t *Timer) bool {
 	b.mu.Lock()
 	defer b.mu.Unlock()
@@ -133,19 +408,50 @@ func (b *bucket) Flush(reinsert func(*Timer)) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	for e := b.timers.Front(); e != nil; {
-		next := e.Next()
-
-		t := e.Value.(*Timer)
-		b.remove(t)
+	for t := b.first(); t != nil; t = b.first() {
+		// Unlink t but keep it referring to b until it has been reinserted,
+		// so that a concurrent Timer.Stop or Timer.Reset waits on b.mu and
+		// then finds t in its new bucket, instead of missing it in between.
+		b.unlink(t)
 		// Note that this operation will either execute the timer's task, or
 		// insert the timer into another bucket belonging to a lower-level wheel.
 		//
 		// In either case, no further lock operation will happen to b.mu.
 		reinsert(t)
-
-		e = next
 	}
 
 	b.SetExpiration(-1)
 }
+
+// timerHeap is a min heap of the timers ordered by their expirations.
+type timerHeap []*Timer
+
+func (h timerHeap) Len() int {
+	return len(h)
+}
+
+func (h timerHeap) Less(i, j int) bool {
+	return h[i].expiration < h[j].expiration
+}
+
+func (h timerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *timerHeap) Push(x interface{}) {
+	t := x.(*Timer)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *timerHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	t := old[n-1]
+	old[n-1] = nil
+	t.index = -1
+	*h = old[:n-1]
+	return t
+}
@@ -0,0 +1,68 @@
+package timingwheel
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// clockJumpThreshold is the minimum change of the offset between the wall
+// clock and the monotonic clock that is regarded as a wall-clock jump.
+const clockJumpThreshold = time.Second
+
+// now returns the current time on the monotonic clock, in the time unit.
+func (tw *TimingWheel) now() int64 {
+	return int64(tw.clock.Elapsed() / tw.unit)
+}
+
+// after returns the time after duration d on the monotonic clock, in the time unit.
+func (tw *TimingWheel) after(d time.Duration) int64 {
+	return int64((tw.clock.Elapsed() + d) / tw.unit)
+}
+
+// wallToUnits maps the wall-clock time t onto the monotonic clock, in the time unit.
+func (tw *TimingWheel) wallToUnits(t time.Time) int64 {
+	return tw.after(t.Sub(tw.clock.Now()))
+}
+
+// unitsToWall maps the time t on the monotonic clock, in the time unit, onto the wall clock.
+func (tw *TimingWheel) unitsToWall(t int64) time.Time {
+	return tw.clock.Now().Add(time.Duration(t)*tw.unit - tw.clock.Elapsed()).UTC()
+}
+
+// clockOffset returns the offset between the wall clock and the monotonic clock,
+// which only changes when the wall clock jumps.
+func (tw *TimingWheel) clockOffset() time.Duration {
+	return time.Duration(tw.clock.Now().UnixNano()) - tw.clock.Elapsed()
+}
+
+// checkClock checks if the wall clock has jumped since the offset was taken,
+// and rebases the timers following the wall clock if so. It returns the new offset.
+func (tw *TimingWheel) checkClock(offset time.Duration) time.Duration {
+	next := tw.clockOffset()
+	if jump := next - offset; jump >= clockJumpThreshold || jump <= -clockJumpThreshold {
+		tw.rebase()
+		if tw.hooks.OnClockJump != nil {
+			tw.hooks.OnClockJump(jump)
+		}
+	}
+
+	return next
+}
+
+// rebase re-adds the pending timers following the wall clock, according to
+// their wall-clock expirations.
+func (tw *TimingWheel) rebase() {
+	var timers []*Timer
+	for w := tw; w != nil; w = (*TimingWheel)(atomic.LoadPointer(&w.overflowWheel)) {
+		for _, b := range w.buckets {
+			timers = b.AppendTo(timers)
+		}
+	}
+	if tw.far != nil {
+		timers = tw.far.AppendTo(timers)
+	}
+
+	for _, t := range timers {
+		t.rebase()
+	}
+}
@@ -0,0 +1,91 @@
+package timingwheel
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shanluzhineng/threadingx/timex"
+)
+
+type intervalScheduler struct {
+	interval time.Duration
+}
+
+func (s intervalScheduler) Next(prev time.Time) time.Time {
+	return prev.Add(s.interval)
+}
+
+type relativeIntervalScheduler struct {
+	intervalScheduler
+}
+
+func (s relativeIntervalScheduler) Relative() bool {
+	return true
+}
+
+// waitFor waits for cond to become true, and fails t after a second.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// advance advances the clock by d in steps, letting the timing wheel catch up.
+func advance(clock timex.FakeClock, d, step time.Duration) {
+	for ; d > 0; d -= step {
+		clock.Advance(step)
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestClockJumpBackward(t *testing.T) {
+	clock := timex.NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	tw := NewTimingWheel(time.Millisecond, 20, WithClock(clock))
+	tw.Start()
+	defer tw.Stop()
+
+	var every, relative, absolute, after int32
+	tw.ScheduleFunc(Every(2*time.Second), func() { atomic.AddInt32(&every, 1) })
+	tw.ScheduleFunc(relativeIntervalScheduler{intervalScheduler{2 * time.Second}},
+		func() { atomic.AddInt32(&relative, 1) })
+	tw.ScheduleFunc(intervalScheduler{2 * time.Second}, func() { atomic.AddInt32(&absolute, 1) })
+	tw.AfterFunc(5*time.Second, func() { atomic.AddInt32(&after, 1) })
+
+	clock.Jump(-time.Hour)
+	advance(clock, 10*time.Second+100*time.Millisecond, 100*time.Millisecond)
+
+	waitFor(t, func() bool {
+		return atomic.LoadInt32(&every) == 5 && atomic.LoadInt32(&relative) == 5 &&
+			atomic.LoadInt32(&after) == 1
+	})
+	if n := atomic.LoadInt32(&absolute); n != 0 {
+		t.Fatalf("the wall-clock scheduler fired %d times after the clock jumped back", n)
+	}
+}
+
+func TestTimerExpirationTime(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := timex.NewFakeClock(start)
+	tw := NewTimingWheel(time.Millisecond, 20, WithClock(clock))
+
+	timer := tw.AfterFunc(time.Minute, func() {})
+	if got := timer.ExpirationTime(); !got.Equal(start.Add(time.Minute)) {
+		t.Fatalf("got %v, want %v", got, start.Add(time.Minute))
+	}
+	if got, want := timer.GetExpiration(), time.Duration(start.Add(time.Minute).UnixMilli()); got != want {
+		t.Fatalf("got %d, want the Unix time %d in milliseconds", got, want)
+	}
+
+	at := start.Add(time.Hour)
+	timer.ResetAt(at)
+	clock.Jump(time.Minute)
+	if got := timer.ExpirationTime(); !got.Equal(at) {
+		t.Fatalf("got %v, want %v", got, at)
+	}
+}
@@ -0,0 +1,451 @@
+package timingwheel
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The parsing and matching of the cron expressions is modeled on
+// https://github.com/robfig/cron/blob/master/parser.go and spec.go
+
+const (
+	// starBit is set in the dom and dow fields if they were specified with a "*" or "?".
+	starBit = 1 << 63
+	// cronYearLimit is the number of years to look ahead before giving up on a schedule.
+	cronYearLimit = 5
+)
+
+type cronBounds struct {
+	min, max uint
+	names    map[string]uint
+}
+
+var (
+	cronSeconds = cronBounds{0, 59, nil}
+	cronMinutes = cronBounds{0, 59, nil}
+	cronHours   = cronBounds{0, 23, nil}
+	cronDom     = cronBounds{1, 31, nil}
+	cronMonths  = cronBounds{1, 12, map[string]uint{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	cronDow = cronBounds{0, 6, map[string]uint{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+
+	cronMacros = map[string]string{
+		"@yearly":   "0 0 0 1 1 *",
+		"@annually": "0 0 0 1 1 *",
+		"@monthly":  "0 0 0 1 * *",
+		"@weekly":   "0 0 0 * * 0",
+		"@daily":    "0 0 0 * * *",
+		"@midnight": "0 0 0 * * *",
+		"@hourly":   "0 0 * * * *",
+	}
+
+	allHours = cronHours.bits(cronHours.min, cronHours.max, 1)
+)
+
+// CronSchedule is a Scheduler based on a cron expression.
+type CronSchedule struct {
+	second, minute, hour, dom, month, dow uint64
+	location                              *time.Location
+}
+
+// everySchedule is a Scheduler that runs at a fixed interval.
+type everySchedule struct {
+	interval time.Duration
+}
+
+// Every returns a Scheduler that runs every interval, starting one interval
+// after the given time. The interval must be positive.
+func Every(interval time.Duration) Scheduler {
+	if interval <= 0 {
+		panic(errors.New("interval must be greater than 0"))
+	}
+
+	return everySchedule{interval: interval}
+}
+
+func (s everySchedule) Next(prev time.Time) time.Time {
+	return prev.Add(s.interval).UTC()
+}
+
+func (s everySchedule) Relative() bool {
+	return true
+}
+
+// ParseCron parses a cron expression in the local time zone and returns a Scheduler.
+//
+// The expression consists of 5 fields (minute, hour, day of month, month and
+// day of week), optionally preceded by a seconds field. The time zone can be
+// selected by prefixing the expression with "CRON_TZ=<zone> " or "TZ=<zone> ".
+// The following macros are supported as well:
+//
+//	@yearly (or @annually), @monthly, @weekly, @daily (or @midnight),
+//	@hourly and @every <duration>
+//
+// On the days of DST transitions, a fixed-time schedule fires once for each
+// wall-clock time it matches: a time skipped by the transition fires at the
+// transition, and a repeated time only fires on its first occurrence.
+// Schedules with a wildcard hour field follow the elapsed time instead.
+func ParseCron(spec string) (Scheduler, error) {
+	return ParseCronInLocation(spec, time.Local)
+}
+
+// ParseCronInLocation is like ParseCron but interprets the expression in the given location,
+// unless the expression selects a time zone itself.
+func ParseCronInLocation(spec string, loc *time.Location) (Scheduler, error) {
+	spec = strings.TrimSpace(spec)
+	if len(spec) == 0 {
+		return nil, errors.New("cron: empty spec string")
+	}
+
+	if strings.HasPrefix(spec, "TZ=") || strings.HasPrefix(spec, "CRON_TZ=") {
+		i := strings.Index(spec, " ")
+		if i < 0 {
+			return nil, fmt.Errorf("cron: missing fields after time zone in %q", spec)
+		}
+		eq := strings.Index(spec, "=")
+		zone, err := time.LoadLocation(spec[eq+1 : i])
+		if err != nil {
+			return nil, fmt.Errorf("cron: invalid time zone %q: %w", spec[eq+1:i], err)
+		}
+		loc = zone
+		spec = strings.TrimSpace(spec[i:])
+	}
+
+	if strings.HasPrefix(spec, "@every ") {
+		interval, err := time.ParseDuration(strings.TrimSpace(spec[len("@every "):]))
+		if err != nil {
+			return nil, fmt.Errorf("cron: invalid duration in %q: %w", spec, err)
+		}
+		if interval <= 0 {
+			return nil, fmt.Errorf("cron: non-positive duration in %q", spec)
+		}
+		return Every(interval), nil
+	}
+
+	if strings.HasPrefix(spec, "@") {
+		expanded, ok := cronMacros[strings.ToLower(spec)]
+		if !ok {
+			return nil, fmt.Errorf("cron: unrecognized descriptor %q", spec)
+		}
+		spec = expanded
+	}
+
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("cron: expected 5 or 6 fields, found %d in %q", len(fields), spec)
+	}
+
+	s := &CronSchedule{location: loc}
+	var err error
+	for i, f := range []struct {
+		field  *uint64
+		bounds cronBounds
+	}{
+		{&s.second, cronSeconds},
+		{&s.minute, cronMinutes},
+		{&s.hour, cronHours},
+		{&s.dom, cronDom},
+		{&s.month, cronMonths},
+		{&s.dow, cronDow},
+	} {
+		if *f.field, err = f.bounds.parseField(fields[i]); err != nil {
+			return nil, fmt.Errorf("cron: %w in %q", err, spec)
+		}
+	}
+
+	return s, nil
+}
+
+// MustParseCron is like ParseCron but panics if the expression cannot be parsed.
+func MustParseCron(spec string) Scheduler {
+	s, err := ParseCron(spec)
+	if err != nil {
+		panic(err)
+	}
+
+	return s
+}
+
+// Next returns the next time matched by s after the given time.
+// It returns a zero time if no time can be found within five years.
+func (s *CronSchedule) Next(prev time.Time) time.Time {
+	if s.hour&allHours == allHours {
+		return s.nextElapsed(prev)
+	}
+
+	return s.nextWallClock(prev)
+}
+
+// nextElapsed searches the matching instants, so a DST transition skips or
+// repeats the matching times in the same way as the elapsed time does.
+func (s *CronSchedule) nextElapsed(prev time.Time) time.Time {
+	t := s.next(prev.In(s.location), s.location)
+	if t.IsZero() {
+		return t
+	}
+
+	return t.UTC()
+}
+
+// nextWallClock searches the matching wall-clock times, and then maps them
+// to instants, so each matching wall-clock time fires exactly once.
+func (s *CronSchedule) nextWallClock(prev time.Time) time.Time {
+	local := prev.In(s.location)
+	wall := time.Date(local.Year(), local.Month(), local.Day(),
+		local.Hour(), local.Minute(), local.Second(), local.Nanosecond(), time.UTC)
+
+	for {
+		wall = s.next(wall, time.UTC)
+		if wall.IsZero() {
+			return wall
+		}
+
+		if t, ok := s.wallToInstant(wall); ok && t.After(prev) {
+			return t.UTC()
+		}
+	}
+}
+
+// wallToInstant maps the wall-clock time (expressed in UTC) to the first
+// instant in s.location showing it, or to the end of the DST gap hiding it.
+func (s *CronSchedule) wallToInstant(wall time.Time) (time.Time, bool) {
+	var first time.Time
+	for _, d := range []time.Duration{-24 * time.Hour, 24 * time.Hour} {
+		_, offset := wall.Add(d).In(s.location).Zone()
+		t := wall.Add(-time.Duration(offset) * time.Second).In(s.location)
+		if t.Day() != wall.Day() || t.Hour() != wall.Hour() || t.Minute() != wall.Minute() {
+			continue
+		}
+		if first.IsZero() || t.Before(first) {
+			first = t
+		}
+	}
+	if !first.IsZero() {
+		return first, true
+	}
+
+	// The wall-clock time is skipped by a DST transition, fire at the transition.
+	_, offset := wall.Add(-24 * time.Hour).In(s.location).Zone()
+	start, _ := wall.Add(-time.Duration(offset) * time.Second).In(s.location).ZoneBounds()
+	return start, !start.IsZero()
+}
+
+// next returns the first time after t matched by s, computed in loc.
+func (s *CronSchedule) next(t time.Time, loc *time.Location) time.Time {
+	// Start at the earliest possible time (the upcoming second).
+	t = t.Add(time.Second - time.Duration(t.Nanosecond())*time.Nanosecond)
+
+	// Whether a field has been incremented.
+	added := false
+	yearLimit := t.Year() + cronYearLimit
+
+WRAP:
+	if t.Year() > yearLimit {
+		return time.Time{}
+	}
+
+	for 1<<uint(t.Month())&s.month == 0 {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+		}
+		t = t.AddDate(0, 1, 0)
+		if t.Month() == time.January {
+			goto WRAP
+		}
+	}
+
+	for !s.dayMatches(t) {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+		}
+		t = t.AddDate(0, 0, 1)
+		// Notice if the hour is no longer midnight due to DST.
+		// Add an hour if it's 23, subtract an hour if it's 1.
+		if t.Hour() != 0 {
+			if t.Hour() > 12 {
+				t = t.Add(time.Duration(24-t.Hour()) * time.Hour)
+			} else {
+				t = t.Add(time.Duration(-t.Hour()) * time.Hour)
+			}
+		}
+		if t.Day() == 1 {
+			goto WRAP
+		}
+	}
+
+	for 1<<uint(t.Hour())&s.hour == 0 {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
+		}
+		t = t.Add(time.Hour)
+		if t.Hour() == 0 {
+			goto WRAP
+		}
+	}
+
+	for 1<<uint(t.Minute())&s.minute == 0 {
+		if !added {
+			added = true
+			t = t.Truncate(time.Minute)
+		}
+		t = t.Add(time.Minute)
+		if t.Minute() == 0 {
+			goto WRAP
+		}
+	}
+
+	for 1<<uint(t.Second())&s.second == 0 {
+		if !added {
+			added = true
+			t = t.Truncate(time.Second)
+		}
+		t = t.Add(time.Second)
+		if t.Second() == 0 {
+			goto WRAP
+		}
+	}
+
+	return t
+}
+
+// dayMatches returns true if the schedule's day-of-week and day-of-month
+// restrictions are satisfied by the given time.
+func (s *CronSchedule) dayMatches(t time.Time) bool {
+	domMatch := 1<<uint(t.Day())&s.dom > 0
+	dowMatch := 1<<uint(t.Weekday())&s.dow > 0
+	if s.dom&starBit > 0 || s.dow&starBit > 0 {
+		return domMatch && dowMatch
+	}
+
+	return domMatch || dowMatch
+}
+
+// parseField returns a bitmask of the values matched by the comma-separated field.
+func (b cronBounds) parseField(field string) (uint64, error) {
+	var bits uint64
+	for _, expr := range strings.Split(field, ",") {
+		bit, err := b.parseRange(expr)
+		if err != nil {
+			return 0, err
+		}
+		bits |= bit
+	}
+
+	return bits, nil
+}
+
+// parseRange returns the bits indicated by the given expression:
+//
+//	number | number "-" number [ "/" number ] | "*" [ "/" number ] | "?"
+func (b cronBounds) parseRange(expr string) (uint64, error) {
+	var (
+		start, end, step uint
+		extra            uint64
+	)
+
+	rangeAndStep := strings.Split(expr, "/")
+	lowAndHigh := strings.Split(rangeAndStep[0], "-")
+	singleDigit := len(lowAndHigh) == 1
+
+	if lowAndHigh[0] == "*" || lowAndHigh[0] == "?" {
+		if !singleDigit {
+			return 0, fmt.Errorf("invalid range %q", expr)
+		}
+		start = b.min
+		end = b.max
+		extra = starBit
+	} else {
+		var err error
+		if start, err = b.parseValue(lowAndHigh[0]); err != nil {
+			return 0, err
+		}
+		switch len(lowAndHigh) {
+		case 1:
+			end = start
+		case 2:
+			if end, err = b.parseValue(lowAndHigh[1]); err != nil {
+				return 0, err
+			}
+		default:
+			return 0, fmt.Errorf("too many hyphens in %q", expr)
+		}
+	}
+
+	switch len(rangeAndStep) {
+	case 1:
+		step = 1
+	case 2:
+		n, err := strconv.ParseUint(rangeAndStep[1], 10, 0)
+		if err != nil || n == 0 {
+			return 0, fmt.Errorf("invalid step in %q", expr)
+		}
+		step = uint(n)
+
+		// "N/step" means "N-max/step".
+		if singleDigit {
+			end = b.max
+		}
+		if step > 1 {
+			extra = 0
+		}
+	default:
+		return 0, fmt.Errorf("too many slashes in %q", expr)
+	}
+
+	if start < b.min {
+		return 0, fmt.Errorf("beginning of range %d below minimum %d in %q", start, b.min, expr)
+	}
+	if end > b.max {
+		return 0, fmt.Errorf("end of range %d above maximum %d in %q", end, b.max, expr)
+	}
+	if start > end {
+		return 0, fmt.Errorf("beginning of range %d beyond end %d in %q", start, end, expr)
+	}
+
+	return b.bits(start, end, step) | extra, nil
+}
+
+// parseValue parses a number or a name of the field.
+func (b cronBounds) parseValue(expr string) (uint, error) {
+	if b.names != nil {
+		if value, ok := b.names[strings.ToLower(expr)]; ok {
+			return value, nil
+		}
+	}
+
+	value, err := strconv.ParseUint(expr, 10, 0)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %q", expr)
+	}
+
+	return uint(value), nil
+}
+
+// bits sets the bits in the range [min, max], stepping by step.
+func (b cronBounds) bits(min, max, step uint) uint64 {
+	// If step is 1, use shifts.
+	if step == 1 {
+		return ^(math.MaxUint64 << (max + 1)) & (math.MaxUint64 << min)
+	}
+
+	var bits uint64
+	for i := min; i <= max; i += step {
+		bits |= 1 << i
+	}
+	return bits
+}
@@ -0,0 +1,102 @@
+package timingwheel
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestCronDST(t *testing.T) {
+	utc := func(s string) time.Time {
+		v, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v.UTC()
+	}
+
+	// In America/New_York, 2024-03-10 02:00 EST jumps to 03:00 EDT,
+	// and 2024-11-03 02:00 EDT falls back to 01:00 EST.
+	tests := []struct {
+		name string
+		spec string
+		from string
+		want []string
+	}{
+		{
+			name: "skipped time fires at the transition",
+			spec: "30 2 * * *",
+			from: "2024-03-09T03:00:00-05:00",
+			want: []string{
+				"2024-03-10T03:00:00-04:00",
+				"2024-03-11T02:30:00-04:00",
+			},
+		},
+		{
+			name: "repeated time fires once",
+			spec: "30 1 * * *",
+			from: "2024-11-02T02:00:00-04:00",
+			want: []string{
+				"2024-11-03T01:30:00-04:00",
+				"2024-11-04T01:30:00-05:00",
+			},
+		},
+		{
+			name: "wildcard hour follows the elapsed time when falling back",
+			spec: "0 * * * *",
+			from: "2024-11-03T00:30:00-04:00",
+			want: []string{
+				"2024-11-03T01:00:00-04:00",
+				"2024-11-03T01:00:00-05:00",
+				"2024-11-03T02:00:00-05:00",
+			},
+		},
+		{
+			name: "wildcard hour follows the elapsed time when springing forward",
+			spec: "0 * * * *",
+			from: "2024-03-10T01:30:00-05:00",
+			want: []string{
+				"2024-03-10T03:00:00-04:00",
+				"2024-03-10T04:00:00-04:00",
+			},
+		},
+		{
+			name: "daily macro on the transition days",
+			spec: "@daily",
+			from: "2024-11-02T12:00:00-04:00",
+			want: []string{
+				"2024-11-03T00:00:00-04:00",
+				"2024-11-04T00:00:00-05:00",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		s := MustParseCron("CRON_TZ=America/New_York " + tt.spec)
+		prev := utc(tt.from)
+		for _, w := range tt.want {
+			next := s.Next(prev)
+			if want := utc(w); !next.Equal(want) {
+				t.Errorf("%s: Next(%v) = %v, want %v", tt.name, prev, next, want)
+				break
+			}
+			prev = next
+		}
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"CRON_TZ=Nowhere/Land * * * * *",
+		"@every -1s",
+		"@unknown",
+	} {
+		if _, err := ParseCron(spec); err == nil {
+			t.Errorf("ParseCron(%q) returned no error", spec)
+		}
+	}
+}
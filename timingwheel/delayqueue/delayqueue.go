@@ -2,145 +2,643 @@ package delayqueue
 
 import (
 	"container/heap"
+	"context"
+	"errors"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/shanluzhineng/threadingx/timex"
 )
 
 // The start of PriorityQueue implementation.
 // Borrowed from https://github.com/nsqio/nsq/blob/master/internal/pqueue/pqueue.go
 
-type item struct {
-	Value    interface{}
-	Priority int64
-	Index    int
+// Handle refers to an element offered to a DelayQueue, which can be used to
+// remove the element or to update its expiration.
+type Handle[T any] struct {
+	value      T
+	expiration int64
+	index      int
+}
+
+// Value returns the element.
+func (h *Handle[T]) Value() T {
+	return h.value
+}
+
+// Expiration returns the current expiration of the element.
+func (h *Handle[T]) Expiration() int64 {
+	return atomic.LoadInt64(&h.expiration)
 }
 
 // this is a priority queue as implemented by a min heap
 // ie. the 0th element is the *lowest* value
-type priorityQueue []*item
+type priorityQueue[T any] []*Handle[T]
 
-func newPriorityQueue(capacity int) priorityQueue {
-	return make(priorityQueue, 0, capacity)
+func newPriorityQueue[T any](capacity int) priorityQueue[T] {
+	return make(priorityQueue[T], 0, capacity)
 }
 
-func (pq priorityQueue) Len() int {
+func (pq priorityQueue[T]) Len() int {
 	return len(pq)
 }
 
-func (pq priorityQueue) Less(i, j int) bool {
-	return pq[i].Priority < pq[j].Priority
+func (pq priorityQueue[T]) Less(i, j int) bool {
+	return pq[i].expiration < pq[j].expiration
 }
 
-func (pq priorityQueue) Swap(i, j int) {
+func (pq priorityQueue[T]) Swap(i, j int) {
 	pq[i], pq[j] = pq[j], pq[i]
-	pq[i].Index = i
-	pq[j].Index = j
+	pq[i].index = i
+	pq[j].index = j
 }
 
-func (pq *priorityQueue) Push(x interface{}) {
+func (pq *priorityQueue[T]) Push(x interface{}) {
 	n := len(*pq)
 	c := cap(*pq)
 	if n+1 > c {
-		npq := make(priorityQueue, n, c*2)
+		npq := make(priorityQueue[T], n, c*2+1)
 		copy(npq, *pq)
 		*pq = npq
 	}
 	*pq = (*pq)[0 : n+1]
-	item := x.(*item)
-	item.Index = n
+	item := x.(*Handle[T])
+	item.index = n
 	(*pq)[n] = item
 }
 
-func (pq *priorityQueue) Pop() interface{} {
+func (pq *priorityQueue[T]) Pop() interface{} {
 	n := len(*pq)
 	c := cap(*pq)
 	if n < (c/2) && c > 25 {
-		npq := make(priorityQueue, n, c/2)
+		npq := make(priorityQueue[T], n, c/2)
 		copy(npq, *pq)
 		*pq = npq
 	}
 	item := (*pq)[n-1]
-	item.Index = -1
+	item.index = -1
+	(*pq)[n-1] = nil
 	*pq = (*pq)[0 : n-1]
 	return item
 }
 
-func (pq *priorityQueue) PeekAndShift(max int64) (*item, int64) {
+func (pq *priorityQueue[T]) PeekAndShift(max int64) (*Handle[T], int64) {
 	if pq.Len() == 0 {
 		return nil, 0
 	}
 
 	item := (*pq)[0]
-	if item.Priority > max {
-		return nil, item.Priority - max
+	if item.expiration > max {
+		return nil, item.expiration - max
 	}
 	heap.Remove(pq, 0)
 
 	return item, 0
 }
 
+// contains checks if h is in pq.
+func (pq priorityQueue[T]) contains(h *Handle[T]) bool {
+	return h != nil && h.index >= 0 && h.index < len(pq) && pq[h.index] == h
+}
+
 // The end of PriorityQueue implementation.
 
-// DelayQueue is an unbounded blocking queue of *Delayed* elements, in which
-// an element can only be taken when its delay has expired. The head of the
-// queue is the *Delayed* element whose delay expired furthest in the past.
-type DelayQueue struct {
-	C chan interface{}
+type (
+	// Option defines the method to customize a DelayQueue.
+	Option func(options *options)
+
+	options struct {
+		clock    timex.Clock
+		unit     time.Duration
+		capacity int
+
+		// only used by Durable.
+		syncWrites       bool
+		compactThreshold int
+		compactInterval  time.Duration
+	}
+)
+
+// WithClock customizes a DelayQueue with the given clock, which is used to
+// wait for the pending elements to expire.
+func WithClock(clock timex.Clock) Option {
+	return func(options *options) {
+		options.clock = clock
+	}
+}
+
+// WithTimeUnit customizes a DelayQueue with the unit of the expirations
+// given to Offer and returned by the nowF of Poll.
+// The default unit is time.Millisecond.
+func WithTimeUnit(unit time.Duration) Option {
+	if unit <= 0 {
+		panic(errors.New("unit must be greater than 0"))
+	}
+
+	return func(options *options) {
+		options.unit = unit
+	}
+}
+
+// WithCapacity customizes a DelayQueue to hold at most capacity elements,
+// the offers beyond wait for the elements to be taken or removed.
+// The default capacity 0 means unbounded.
+func WithCapacity(capacity int) Option {
+	if capacity < 0 {
+		panic(errors.New("capacity must not be negative"))
+	}
+
+	return func(options *options) {
+		options.capacity = capacity
+	}
+}
+
+// WithSyncWrites customizes a Durable to sync its log to the disk after each
+// write, so that the written records survive a power loss, not only a crash
+// of the process.
+func WithSyncWrites(sync bool) Option {
+	return func(options *options) {
+		options.syncWrites = sync
+	}
+}
+
+// WithCompactThreshold customizes a Durable to compact its log once it holds
+// at least threshold records of the acknowledged elements, and no less than
+// the records of the unacknowledged ones. The default threshold is 1024.
+func WithCompactThreshold(threshold int) Option {
+	if threshold <= 0 {
+		panic(errors.New("threshold must be greater than 0"))
+	}
+
+	return func(options *options) {
+		options.compactThreshold = threshold
+	}
+}
+
+// WithCompactInterval customizes a Durable to compact its log every interval
+// if it holds any record of the acknowledged elements, in addition to the
+// compactions triggered by Ack according to WithCompactThreshold. The default
+// interval 0 disables the periodic compaction.
+func WithCompactInterval(interval time.Duration) Option {
+	if interval < 0 {
+		panic(errors.New("interval must not be negative"))
+	}
+
+	return func(options *options) {
+		options.compactInterval = interval
+	}
+}
+
+func newOptions() options {
+	return options{
+		clock:            timex.NewClock(),
+		unit:             time.Millisecond,
+		compactThreshold: defaultCompactThreshold,
+	}
+}
+
+// DelayQueue is a blocking queue of *Delayed* elements, in which an element
+// can only be taken when its delay has expired. The head of the queue is the
+// *Delayed* element whose delay expired furthest in the past. The queue is
+// unbounded, unless a capacity is given by WithCapacity.
+//
+// The expired elements are either sent to the channels C and BatchC by Poll and
+// PollBatch, or taken by Take, TakeBatch, TryTake and DrainExpired, which regard
+// the expirations as Unix times in the time unit.
+type DelayQueue[T any] struct {
+	C chan T
+	// BatchC receives the expired elements in batches from PollBatch.
+	BatchC chan []T
 
 	mu sync.Mutex
-	pq priorityQueue
+	pq priorityQueue[T]
+
+	clock    timex.Clock
+	unit     time.Duration
+	capacity int
 
 	// Similar to the sleeping state of runtime.timers.
 	sleeping int32
 	wakeupC  chan struct{}
+
+	// The number of the callers of Take and TakeBatch waiting on notifyC, which is closed
+	// and replaced when the head of the queue changes. Guarded by mu.
+	takers  int
+	notifyC chan struct{}
+	// The number of the callers of OfferCtx waiting on spaceC, which is closed
+	// and replaced when an element leaves the queue. Guarded by mu.
+	offerers int
+	spaceC   chan struct{}
+}
+
+// Stats is a snapshot of the statistics of a DelayQueue.
+type Stats struct {
+	// Depth is the number of the elements in the queue, expired or not.
+	Depth int
+	// Capacity is the maximum number of the elements, 0 if unbounded.
+	Capacity int
+	// Oldest is the earliest expiration of the elements, 0 if the queue is empty.
+	Oldest int64
 }
 
 // New creates an instance of delayQueue with the specified size.
-func New(size int) *DelayQueue {
-	return &DelayQueue{
-		C:       make(chan interface{}),
-		pq:      newPriorityQueue(size),
-		wakeupC: make(chan struct{}),
+func New[T any](size int, opts ...Option) *DelayQueue[T] {
+	options := newOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	return &DelayQueue[T]{
+		C:        make(chan T),
+		BatchC:   make(chan []T),
+		pq:       newPriorityQueue[T](size),
+		clock:    options.clock,
+		unit:     options.unit,
+		capacity: options.capacity,
+		wakeupC:  make(chan struct{}),
+		notifyC:  make(chan struct{}),
+		spaceC:   make(chan struct{}),
 	}
 }
 
-// Offer inserts the element into the current queue.
-func (dq *DelayQueue) Offer(elem interface{}, expiration int64) {
-	item := &item{Value: elem, Priority: expiration}
+// Offer inserts the element into the current queue, and returns its handle.
+// If the queue is full, Offer waits until there is room for the element.
+func (dq *DelayQueue[T]) Offer(elem T, expiration int64) *Handle[T] {
+	h, _ := dq.OfferCtx(context.Background(), elem, expiration)
+	return h
+}
 
+// OfferCtx is like Offer, but returns ctx.Err() if ctx is done before there
+// is room for the element.
+func (dq *DelayQueue[T]) OfferCtx(ctx context.Context, elem T, expiration int64) (*Handle[T], error) {
 	dq.mu.Lock()
-	heap.Push(&dq.pq, item)
-	index := item.Index
+	for dq.fullLocked() {
+		dq.offerers++
+		spaceC := dq.spaceC
+		dq.mu.Unlock()
+
+		var err error
+		select {
+		case <-spaceC:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+
+		dq.mu.Lock()
+		dq.offerers--
+		if err != nil {
+			dq.mu.Unlock()
+			return nil, err
+		}
+	}
+
+	return dq.pushAndUnlock(elem, expiration), nil
+}
+
+// TryOffer is like Offer, but returns false instead of waiting if the queue is full.
+func (dq *DelayQueue[T]) TryOffer(elem T, expiration int64) (*Handle[T], bool) {
+	dq.mu.Lock()
+	if dq.fullLocked() {
+		dq.mu.Unlock()
+		return nil, false
+	}
+
+	return dq.pushAndUnlock(elem, expiration), true
+}
+
+// Stats returns a snapshot of the statistics of the queue.
+func (dq *DelayQueue[T]) Stats() Stats {
+	dq.mu.Lock()
+	defer dq.mu.Unlock()
+
+	stats := Stats{
+		Depth:    dq.pq.Len(),
+		Capacity: dq.capacity,
+	}
+	if stats.Depth > 0 {
+		stats.Oldest = dq.pq[0].expiration
+	}
+	return stats
+}
+
+// pushAndUnlock inserts the element into the queue, must be called with dq.mu
+// held, which is released on return.
+func (dq *DelayQueue[T]) pushAndUnlock(elem T, expiration int64) *Handle[T] {
+	h := &Handle[T]{value: elem, expiration: expiration}
+
+	heap.Push(&dq.pq, h)
+	head := h.index == 0
+	if head {
+		dq.notifyLocked()
+	}
 	dq.mu.Unlock()
 
-	if index == 0 {
+	if head {
 		// A new item with the earliest expiration is added.
-		if atomic.CompareAndSwapInt32(&dq.sleeping, 1, 0) {
-			dq.wakeupC <- struct{}{}
+		dq.wakeup()
+	}
+	return h
+}
+
+// fullLocked checks if the queue is full, must be called with dq.mu held.
+func (dq *DelayQueue[T]) fullLocked() bool {
+	return dq.capacity > 0 && dq.pq.Len() >= dq.capacity
+}
+
+// shiftLocked removes and returns the element expired at now, or returns the
+// delay of the earliest element like PeekAndShift. Must be called with dq.mu held.
+func (dq *DelayQueue[T]) shiftLocked(now int64) (*Handle[T], int64) {
+	h, delta := dq.pq.PeekAndShift(now)
+	if h != nil {
+		dq.releaseLocked()
+	}
+	return h, delta
+}
+
+// Len returns the number of the elements in the queue, expired or not.
+func (dq *DelayQueue[T]) Len() int {
+	dq.mu.Lock()
+	defer dq.mu.Unlock()
+	return dq.pq.Len()
+}
+
+// Peek returns the element with the earliest expiration along with the
+// expiration, without removing it. It returns false if the queue is empty.
+func (dq *DelayQueue[T]) Peek() (T, int64, bool) {
+	dq.mu.Lock()
+	defer dq.mu.Unlock()
+
+	if dq.pq.Len() == 0 {
+		var zero T
+		return zero, 0, false
+	}
+
+	h := dq.pq[0]
+	return h.value, h.expiration, true
+}
+
+// Remove removes the element of h from the queue. It returns false if the
+// element has already been taken or removed.
+func (dq *DelayQueue[T]) Remove(h *Handle[T]) bool {
+	dq.mu.Lock()
+	defer dq.mu.Unlock()
+
+	if !dq.pq.contains(h) {
+		return false
+	}
+
+	// Removing the head only delays the next expiration, the waiters
+	// find it out once they wake up.
+	heap.Remove(&dq.pq, h.index)
+	dq.releaseLocked()
+	return true
+}
+
+// UpdateDelay changes the expiration of the element of h. It returns false
+// if the element has already been taken or removed.
+func (dq *DelayQueue[T]) UpdateDelay(h *Handle[T], expiration int64) bool {
+	dq.mu.Lock()
+	if !dq.pq.contains(h) {
+		dq.mu.Unlock()
+		return false
+	}
+
+	atomic.StoreInt64(&h.expiration, expiration)
+	heap.Fix(&dq.pq, h.index)
+	head := h.index == 0
+	if head {
+		dq.notifyLocked()
+	}
+	dq.mu.Unlock()
+
+	if head {
+		// The element becomes the one with the earliest expiration.
+		dq.wakeup()
+	}
+	return true
+}
+
+// TryTake takes an expired element without waiting. It returns false if
+// no element has expired.
+func (dq *DelayQueue[T]) TryTake() (T, bool) {
+	now := dq.now()
+
+	dq.mu.Lock()
+	h, _ := dq.shiftLocked(now)
+	dq.mu.Unlock()
+
+	if h == nil {
+		var zero T
+		return zero, false
+	}
+	return h.value, true
+}
+
+// Take waits for an element to expire and takes it. It returns ctx.Err()
+// if ctx is done before.
+func (dq *DelayQueue[T]) Take(ctx context.Context) (T, error) {
+	var value T
+	err := dq.wait(ctx, func(now int64) (bool, int64) {
+		h, delta := dq.shiftLocked(now)
+		if h != nil {
+			value = h.value
+		}
+		return h != nil, delta
+	})
+
+	return value, err
+}
+
+// TakeBatch waits for some elements to expire and takes all the expired ones,
+// at most max elements if max is greater than 0. It returns ctx.Err() if ctx
+// is done before.
+func (dq *DelayQueue[T]) TakeBatch(ctx context.Context, max int) ([]T, error) {
+	var batch []T
+	err := dq.wait(ctx, func(now int64) (bool, int64) {
+		var delta int64
+		batch, delta = dq.drainLocked(now, max)
+		return len(batch) > 0, delta
+	})
+
+	return batch, err
+}
+
+// DrainExpired takes all the expired elements without waiting, at most max
+// elements if max is greater than 0.
+func (dq *DelayQueue[T]) DrainExpired(max int) []T {
+	now := dq.now()
+
+	dq.mu.Lock()
+	defer dq.mu.Unlock()
+	batch, _ := dq.drainLocked(now, max)
+	return batch
+}
+
+// wait waits until take, which is called with dq.mu held, takes the elements
+// expired at now. If there is none, take returns the delay of the earliest
+// element, or 0 if the queue is empty.
+func (dq *DelayQueue[T]) wait(ctx context.Context, take func(now int64) (bool, int64)) error {
+	// The timer to wait for the "earliest" item, reused across the iterations.
+	var timer timex.Timer
+
+	for {
+		now := dq.now()
+
+		dq.mu.Lock()
+		taken, delta := take(now)
+		if taken {
+			dq.mu.Unlock()
+			return nil
+		}
+		dq.takers++
+		notifyC := dq.notifyC
+		dq.mu.Unlock()
+
+		var timerC <-chan time.Time
+		if delta > 0 {
+			// At least one item is pending.
+			if timer == nil {
+				timer = dq.clock.NewTimer(time.Duration(delta) * dq.unit)
+			} else {
+				timer.Reset(time.Duration(delta) * dq.unit)
+			}
+			timerC = timer.Chan()
+		}
+
+		var err error
+		select {
+		case <-notifyC:
+			// The head of the queue has changed.
+		case <-timerC:
+			// The current "earliest" item expires.
+		case <-ctx.Done():
+			err = ctx.Err()
 		}
+		if timerC != nil {
+			stopTimer(timer)
+		}
+
+		dq.mu.Lock()
+		dq.takers--
+		dq.mu.Unlock()
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// drainLocked removes and returns the elements expired at now, at most max
+// elements if max is greater than 0. If there is none, it returns the delay of
+// the earliest element like PeekAndShift. Must be called with dq.mu held.
+func (dq *DelayQueue[T]) drainLocked(now int64, max int) ([]T, int64) {
+	var batch []T
+	for max <= 0 || len(batch) < max {
+		h, delta := dq.shiftLocked(now)
+		if h == nil {
+			return batch, delta
+		}
+		batch = append(batch, h.value)
+	}
+
+	return batch, 0
+}
+
+// now returns the current Unix time in the time unit.
+func (dq *DelayQueue[T]) now() int64 {
+	return dq.clock.Now().UnixNano() / int64(dq.unit)
+}
+
+// notifyLocked wakes up the callers of Take, must be called with dq.mu held.
+func (dq *DelayQueue[T]) notifyLocked() {
+	if dq.takers > 0 {
+		close(dq.notifyC)
+		dq.notifyC = make(chan struct{})
+	}
+}
+
+// releaseLocked wakes up the callers of OfferCtx once an element leaves the
+// queue, must be called with dq.mu held.
+func (dq *DelayQueue[T]) releaseLocked() {
+	if dq.offerers > 0 {
+		close(dq.spaceC)
+		dq.spaceC = make(chan struct{})
+	}
+}
+
+// wakeup wakes up Poll if it is sleeping.
+func (dq *DelayQueue[T]) wakeup() {
+	if atomic.CompareAndSwapInt32(&dq.sleeping, 1, 0) {
+		dq.wakeupC <- struct{}{}
 	}
 }
 
 // Poll starts an infinite loop, in which it continually waits for an element
 // to expire and then send the expired element to the channel C.
-func (dq *DelayQueue) Poll(exitC chan struct{}, nowF func() int64) {
+//
+// Only one goroutine may poll the queue at a time, while any number of
+// goroutines may receive from C, or call Take and TakeBatch concurrently.
+func (dq *DelayQueue[T]) Poll(exitC chan struct{}, nowF func() int64) {
+	var item *Handle[T]
+	dq.poll(exitC, nowF, func(now int64) (bool, int64) {
+		var delta int64
+		item, delta = dq.shiftLocked(now)
+		return item != nil, delta
+	}, func() bool {
+		select {
+		case dq.C <- item.value:
+			// The expired element has been sent out successfully.
+			return true
+		case <-exitC:
+			return false
+		}
+	})
+}
+
+// PollBatch is like Poll, but sends all the expired elements to the channel
+// BatchC in one slice, at most max elements if max is greater than 0.
+func (dq *DelayQueue[T]) PollBatch(exitC chan struct{}, nowF func() int64, max int) {
+	var batch []T
+	dq.poll(exitC, nowF, func(now int64) (bool, int64) {
+		var delta int64
+		batch, delta = dq.drainLocked(now, max)
+		return len(batch) > 0, delta
+	}, func() bool {
+		select {
+		case dq.BatchC <- batch:
+			// The expired elements have been sent out successfully.
+			return true
+		case <-exitC:
+			return false
+		}
+	})
+}
+
+// poll is the loop of Poll and PollBatch. The take is called with dq.mu held to
+// take the elements expired at now like the one of wait, and send sends them
+// out, it returns false if exitC is closed meanwhile.
+func (dq *DelayQueue[T]) poll(exitC chan struct{}, nowF func() int64, take func(now int64) (bool, int64),
+	send func() bool) {
+	// The timer to wait for the "earliest" item, reused across the iterations.
+	var timer timex.Timer
+
 	for {
 		now := nowF()
 
 		dq.mu.Lock()
-		item, delta := dq.pq.PeekAndShift(now)
-		if item == nil {
+		taken, delta := take(now)
+		if !taken {
 			// No items left or at least one item is pending.
 
 			// We must ensure the atomicity of the whole operation, which is
-			// composed of the above PeekAndShift and the following StoreInt32,
+			// composed of the above take and the following StoreInt32,
 			// to avoid possible race conditions between Offer and Poll.
 			atomic.StoreInt32(&dq.sleeping, 1)
 		}
 		dq.mu.Unlock()
 
-		if item == nil {
+		if !taken {
 			if delta == 0 {
 				// No items left.
 				select {
@@ -152,11 +650,18 @@ func (dq *DelayQueue) Poll(exitC chan struct{}, nowF func() int64) {
 				}
 			} else if delta > 0 {
 				// At least one item is pending.
+				if timer == nil {
+					timer = dq.clock.NewTimer(time.Duration(delta) * dq.unit)
+				} else {
+					timer.Reset(time.Duration(delta) * dq.unit)
+				}
+
 				select {
 				case <-dq.wakeupC:
 					// A new item with an "earlier" expiration than the current "earliest" one is added.
+					stopTimer(timer)
 					continue
-				case <-time.After(time.Duration(delta) * time.Millisecond):
+				case <-timer.Chan():
 					// The current "earliest" item expires.
 
 					// Reset the sleeping state since there's no need to receive from wakeupC.
@@ -167,15 +672,13 @@ func (dq *DelayQueue) Poll(exitC chan struct{}, nowF func() int64) {
 					}
 					continue
 				case <-exitC:
+					stopTimer(timer)
 					goto exit
 				}
 			}
 		}
 
-		select {
-		case dq.C <- item.Value:
-			// The expired element has been sent out successfully.
-		case <-exitC:
+		if !send() {
 			goto exit
 		}
 	}
@@ -184,3 +687,15 @@ exit:
 	// Reset the states
 	atomic.StoreInt32(&dq.sleeping, 0)
 }
+
+// stopTimer stops the timer and drains its channel if it has fired meanwhile,
+// so that the timer can be reset without receiving a stale time. A time sent
+// after the draining only causes a spurious wakeup, which Poll tolerates.
+func stopTimer(timer timex.Timer) {
+	if !timer.Stop() {
+		select {
+		case <-timer.Chan():
+		default:
+		}
+	}
+}
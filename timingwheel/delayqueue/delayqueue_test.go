@@ -0,0 +1,252 @@
+package delayqueue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/shanluzhineng/threadingx/timex"
+)
+
+func TestFakeClockTryTake(t *testing.T) {
+	clock := timex.NewFakeClock(time.Unix(1000, 0))
+	dq := New[string](4, WithClock(clock))
+	now := dq.now()
+	dq.Offer("c", now+30)
+	dq.Offer("a", now+10)
+	dq.Offer("b", now+20)
+
+	take := func(want ...string) {
+		t.Helper()
+		for _, w := range want {
+			if got, ok := dq.TryTake(); !ok || got != w {
+				t.Fatalf("got %q, %v, want %q", got, ok, w)
+			}
+		}
+		if got, ok := dq.TryTake(); ok {
+			t.Fatalf("took %q before it expired", got)
+		}
+	}
+
+	take()
+	clock.Advance(15 * time.Millisecond)
+	take("a")
+	clock.Advance(4 * time.Millisecond)
+	take()
+	clock.Advance(20 * time.Millisecond)
+	take("b", "c")
+}
+
+func TestFakeClockTake(t *testing.T) {
+	clock := timex.NewFakeClock(time.Unix(1000, 0))
+	dq := New[int](4, WithClock(clock))
+	dq.Offer(1, dq.now()+int64(time.Second/time.Millisecond))
+
+	taken := make(chan int, 1)
+	go func() {
+		v, err := dq.Take(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+		taken <- v
+	}()
+
+	for clock.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(999 * time.Millisecond)
+	select {
+	case v := <-taken:
+		t.Fatalf("took %d before it expired", v)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	for clock.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(time.Millisecond)
+	select {
+	case v := <-taken:
+		if v != 1 {
+			t.Fatalf("got %d, want 1", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the expired element was not taken")
+	}
+}
+
+// waitForTimer waits for a waiter of the clock, that is the timer of Take or Poll.
+func waitForTimer(t *testing.T, clock timex.FakeClock) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for clock.Waiters() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for a timer")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRemoveAndUpdateDelay(t *testing.T) {
+	clock := timex.NewFakeClock(time.Unix(1000, 0))
+	dq := New[string](4, WithClock(clock))
+	now := dq.now()
+	dq.Offer("a", now+10)
+	b := dq.Offer("b", now+20)
+	c := dq.Offer("c", now+30)
+
+	if !dq.Remove(b) || dq.Remove(b) {
+		t.Fatal("Remove should succeed only once")
+	}
+	if !dq.UpdateDelay(c, now+5) {
+		t.Fatal("UpdateDelay failed on a queued element")
+	}
+	if got, expiration, _ := dq.Peek(); got != "c" || expiration != now+5 {
+		t.Fatalf("got %q at %d, want c at %d", got, expiration, now+5)
+	}
+
+	clock.Advance(15 * time.Millisecond)
+	for _, want := range []string{"c", "a"} {
+		if got, ok := dq.TryTake(); !ok || got != want {
+			t.Fatalf("got %q, %v, want %q", got, ok, want)
+		}
+	}
+	if dq.Len() != 0 {
+		t.Fatal("the removed element is still queued")
+	}
+	if dq.UpdateDelay(c, now) || dq.Remove(c) {
+		t.Fatal("the handle of a taken element is still usable")
+	}
+}
+
+func TestOfferCtxWaitsForCapacity(t *testing.T) {
+	clock := timex.NewFakeClock(time.Unix(1000, 0))
+	dq := New[int](1, WithClock(clock), WithCapacity(1))
+	h := dq.Offer(1, dq.now()+10)
+
+	if _, ok := dq.TryOffer(2, dq.now()); ok {
+		t.Fatal("TryOffer succeeded beyond the capacity")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := dq.OfferCtx(ctx, 2, dq.now()); err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	offered := make(chan error, 1)
+	go func() {
+		_, err := dq.OfferCtx(context.Background(), 2, dq.now())
+		offered <- err
+	}()
+	select {
+	case <-offered:
+		t.Fatal("offered beyond the capacity")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	dq.Remove(h)
+	select {
+	case err := <-offered:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the offer is not woken up by Remove")
+	}
+	if stats := dq.Stats(); stats.Depth != 1 || stats.Capacity != 1 {
+		t.Fatalf("got the stats %+v", stats)
+	}
+}
+
+func TestFakeClockPollBatch(t *testing.T) {
+	clock := timex.NewFakeClock(time.Unix(1000, 0))
+	dq := New[int](4, WithClock(clock))
+	now := dq.now()
+	for i := 0; i < 3; i++ {
+		dq.Offer(i, now+8+int64(i))
+	}
+	dq.Offer(3, now+20)
+
+	exitC := make(chan struct{})
+	defer close(exitC)
+	go dq.PollBatch(exitC, dq.now, 2)
+
+	receive := func(want ...int) {
+		t.Helper()
+		select {
+		case batch := <-dq.BatchC:
+			if len(batch) != len(want) {
+				t.Fatalf("got %v, want %v", batch, want)
+			}
+			for i := range want {
+				if batch[i] != want[i] {
+					t.Fatalf("got %v, want %v", batch, want)
+				}
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("did not receive %v", want)
+		}
+	}
+
+	waitForTimer(t, clock)
+	clock.Advance(10 * time.Millisecond)
+	// At most 2 elements per batch.
+	receive(0, 1)
+	receive(2)
+
+	select {
+	case batch := <-dq.BatchC:
+		t.Fatalf("received %v before it expired", batch)
+	case <-time.After(20 * time.Millisecond):
+	}
+	waitForTimer(t, clock)
+	clock.Advance(10 * time.Millisecond)
+	receive(3)
+}
+
+func TestWithTimeUnit(t *testing.T) {
+	clock := timex.NewFakeClock(time.Unix(1000, 0))
+	dq := New[string](4, WithClock(clock), WithTimeUnit(time.Microsecond))
+	now := dq.now()
+	if want := time.Unix(1000, 0).UnixNano() / int64(time.Microsecond); now != want {
+		t.Fatalf("got now %d, want %d in microseconds", now, want)
+	}
+	dq.Offer("b", now+500)
+	dq.Offer("a", now+100)
+
+	clock.Advance(99 * time.Microsecond)
+	if got, ok := dq.TryTake(); ok {
+		t.Fatalf("took %q before it expired", got)
+	}
+	clock.Advance(time.Microsecond)
+	if got, ok := dq.TryTake(); !ok || got != "a" {
+		t.Fatalf("got %q, %v, want a", got, ok)
+	}
+
+	taken := make(chan string, 1)
+	go func() {
+		v, _ := dq.Take(context.Background())
+		taken <- v
+	}()
+	waitForTimer(t, clock)
+	clock.Advance(400 * time.Microsecond)
+	select {
+	case v := <-taken:
+		if v != "b" {
+			t.Fatalf("got %q, want b", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the element was not taken in time")
+	}
+
+	for _, unit := range []time.Duration{0, -time.Millisecond} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("WithTimeUnit accepted the unit %v", unit)
+				}
+			}()
+			WithTimeUnit(unit)
+		}()
+	}
+}
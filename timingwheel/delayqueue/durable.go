@@ -0,0 +1,531 @@
+package delayqueue
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shanluzhineng/threadingx/timex"
+)
+
+const (
+	defaultCompactThreshold = 1024
+	// recordHeaderSize is the size of the header of a log record, which holds
+	// the size and the CRC-32 checksum of the record body.
+	recordHeaderSize = 8
+	// maxRecordSize is the maximum size of a record body, a larger size means
+	// the header is corrupted.
+	maxRecordSize = 1 << 26
+
+	opOffer = "offer"
+	opAck   = "ack"
+	// opSeq records the last assigned ID, so that the IDs are not reused
+	// once the records of the acknowledged elements have been compacted.
+	opSeq = "seq"
+)
+
+var (
+	// ErrClosed is returned when a Durable has been closed.
+	ErrClosed = errors.New("delayqueue: durable queue closed")
+	// ErrNotFound is returned when acknowledging an element that has not been
+	// offered, or has already been acknowledged.
+	ErrNotFound = errors.New("delayqueue: element not found")
+
+	errCorruptRecord = errors.New("delayqueue: corrupt log record")
+)
+
+type (
+	// Durable is a DelayQueue persisted to a write-ahead log file, which holds
+	// its elements until they are acknowledged, so that they are delivered at
+	// least once, even across the restarts.
+	//
+	// The elements are encoded in JSON. Each element gets an ID when offered,
+	// which is used to acknowledge it once it has been handled. The elements
+	// taken but not acknowledged are delivered again after the next open.
+	//
+	// The log is compacted by Ack once it holds enough records of the
+	// acknowledged elements, see WithCompactThreshold, and periodically if
+	// WithCompactInterval is given.
+	Durable[T any] struct {
+		queue *DelayQueue[uint64]
+
+		mu     sync.Mutex
+		path   string
+		file   *os.File
+		items  map[uint64]*durableItem[T]
+		nextID uint64
+		// The number of the records in the log that are not needed anymore.
+		garbage int
+		closed  bool
+		// Closed on Close to stop the periodic compaction, nil if none.
+		exitC chan struct{}
+
+		syncWrites       bool
+		compactThreshold int
+	}
+
+	durableItem[T any] struct {
+		value      T
+		expiration int64
+		// The handle in the queue, nil if the element has not been queued yet.
+		handle *Handle[uint64]
+		taken  bool
+	}
+
+	logRecord struct {
+		Op         string          `json:"op"`
+		ID         uint64          `json:"id"`
+		Expiration int64           `json:"expiration,omitempty"`
+		Value      json.RawMessage `json:"value,omitempty"`
+	}
+)
+
+// OpenDurable opens the log file at path, creating it if necessary, and returns
+// a Durable holding the unacknowledged elements of the log.
+//
+// A torn or corrupted record, usually left by a crash in the middle of a
+// write, ends the log, so it is truncated along with the records after it.
+func OpenDurable[T any](path string, opts ...Option) (*Durable[T], error) {
+	options := newOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Durable[T]{
+		queue:            New[uint64](0, opts...),
+		path:             path,
+		file:             file,
+		items:            make(map[uint64]*durableItem[T]),
+		nextID:           1,
+		syncWrites:       options.syncWrites,
+		compactThreshold: options.compactThreshold,
+	}
+	if err := d.replay(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	// Queue the elements in the order they were offered, regardless of the
+	// capacity, since they were already in the queue before.
+	for _, id := range d.sortedIDs() {
+		item := d.items[id]
+		d.queue.mu.Lock()
+		item.handle = d.queue.pushAndUnlock(id, item.expiration)
+	}
+
+	if options.compactInterval > 0 {
+		d.exitC = make(chan struct{})
+		timer := options.clock.NewTimer(options.compactInterval)
+		go d.compactPeriodically(timer, options.compactInterval)
+	}
+
+	return d, nil
+}
+
+// Offer writes the element to the log and inserts it into the queue, and
+// returns its ID. If the queue is full, Offer waits until there is room.
+func (d *Durable[T]) Offer(elem T, expiration int64) (uint64, error) {
+	value, err := json.Marshal(elem)
+	if err != nil {
+		return 0, err
+	}
+
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return 0, ErrClosed
+	}
+
+	id := d.nextID
+	if err := d.appendLocked(logRecord{
+		Op:         opOffer,
+		ID:         id,
+		Expiration: expiration,
+		Value:      value,
+	}); err != nil {
+		d.mu.Unlock()
+		return 0, err
+	}
+	d.nextID++
+	item := &durableItem[T]{
+		value:      elem,
+		expiration: expiration,
+	}
+	d.items[id] = item
+	d.mu.Unlock()
+
+	h := d.queue.Offer(id, expiration)
+
+	d.mu.Lock()
+	if d.items[id] != item {
+		// Acknowledged before being queued.
+		d.queue.Remove(h)
+	} else if !item.taken {
+		item.handle = h
+	}
+	d.mu.Unlock()
+
+	return id, nil
+}
+
+// Take waits for an element to expire and takes it along with its ID. It returns
+// ctx.Err() if ctx is done before. The element must be acknowledged by Ack once
+// handled, otherwise it is delivered again after the next open.
+func (d *Durable[T]) Take(ctx context.Context) (uint64, T, error) {
+	for {
+		id, err := d.queue.Take(ctx)
+		if err != nil {
+			var zero T
+			return 0, zero, err
+		}
+
+		if value, ok := d.take(id); ok {
+			return id, value, nil
+		}
+	}
+}
+
+// TryTake is like Take, but returns false instead of waiting if no element has expired.
+func (d *Durable[T]) TryTake() (uint64, T, bool) {
+	for {
+		id, ok := d.queue.TryTake()
+		if !ok {
+			var zero T
+			return 0, zero, false
+		}
+
+		if value, ok := d.take(id); ok {
+			return id, value, true
+		}
+	}
+}
+
+// Ack acknowledges the element with the given ID, which is then removed from
+// the log. Acknowledging an element before it is taken cancels it.
+func (d *Durable[T]) Ack(id uint64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return ErrClosed
+	}
+
+	item, ok := d.items[id]
+	if !ok {
+		return ErrNotFound
+	}
+
+	if err := d.appendLocked(logRecord{
+		Op: opAck,
+		ID: id,
+	}); err != nil {
+		return err
+	}
+	delete(d.items, id)
+	if item.handle != nil {
+		d.queue.Remove(item.handle)
+	}
+
+	// Both the offer and the ack records are garbage now.
+	d.garbage += 2
+	if d.garbage >= d.compactThreshold && d.garbage >= len(d.items) {
+		// The ack has been recorded anyway, the log is compacted next time.
+		if err := d.compactLocked(); err != nil {
+			log.Printf("delayqueue: failed to compact %s: %v", d.path, err)
+		}
+	}
+
+	return nil
+}
+
+// Compact rewrites the log with the unacknowledged elements only.
+func (d *Durable[T]) Compact() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return ErrClosed
+	}
+
+	return d.compactLocked()
+}
+
+// Len returns the number of the unacknowledged elements, taken or not.
+func (d *Durable[T]) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.items)
+}
+
+// Close closes the log file. The elements can still be taken afterwards,
+// but can not be offered or acknowledged anymore.
+func (d *Durable[T]) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return nil
+	}
+
+	d.closed = true
+	if d.exitC != nil {
+		close(d.exitC)
+	}
+	return d.file.Close()
+}
+
+// compactPeriodically compacts the log each time the timer fires, if it holds
+// any record of the acknowledged elements, until d is closed.
+func (d *Durable[T]) compactPeriodically(timer timex.Timer, interval time.Duration) {
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.Chan():
+			d.mu.Lock()
+			if !d.closed && d.garbage > 0 {
+				if err := d.compactLocked(); err != nil {
+					log.Printf("delayqueue: failed to compact %s: %v", d.path, err)
+				}
+			}
+			d.mu.Unlock()
+			timer.Reset(interval)
+		case <-d.exitC:
+			return
+		}
+	}
+}
+
+// take marks the element with the given ID as taken, and returns it. It returns
+// false if the element has been acknowledged in the meantime.
+func (d *Durable[T]) take(id uint64) (T, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	item, ok := d.items[id]
+	if !ok || item.taken {
+		var zero T
+		return zero, false
+	}
+
+	item.taken = true
+	item.handle = nil
+	return item.value, true
+}
+
+// replay applies the records of the log, and truncates the log after the last valid one.
+func (d *Durable[T]) replay() error {
+	r := bufio.NewReader(d.file)
+	var offset int64
+	var records int
+	for {
+		rec, size, err := readRecord(r)
+		if err == io.EOF || err == io.ErrUnexpectedEOF || err == errCorruptRecord {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		switch rec.Op {
+		case opOffer:
+			var value T
+			if err := json.Unmarshal(rec.Value, &value); err != nil {
+				return err
+			}
+			d.items[rec.ID] = &durableItem[T]{
+				value:      value,
+				expiration: rec.Expiration,
+			}
+		case opAck:
+			delete(d.items, rec.ID)
+		}
+		if rec.ID >= d.nextID {
+			d.nextID = rec.ID + 1
+		}
+		offset += size
+		records++
+	}
+	d.garbage = records - len(d.items)
+
+	if err := d.file.Truncate(offset); err != nil {
+		return err
+	}
+	_, err := d.file.Seek(offset, io.SeekStart)
+	return err
+}
+
+// compactLocked rewrites the log with the unacknowledged elements only, must
+// be called with d.mu held. The log is left untouched if anything fails before
+// the new log replaces it.
+func (d *Durable[T]) compactLocked() (err error) {
+	tmp := d.path + ".compact"
+	file, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	var renamed bool
+	defer func() {
+		if err != nil && !renamed {
+			file.Close()
+			os.Remove(tmp)
+		}
+	}()
+
+	w := bufio.NewWriter(file)
+	buf, err := encodeRecord(logRecord{
+		Op: opSeq,
+		ID: d.nextID - 1,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(buf); err != nil {
+		return err
+	}
+
+	for _, id := range d.sortedIDs() {
+		item := d.items[id]
+		value, err := json.Marshal(item.value)
+		if err != nil {
+			return err
+		}
+
+		buf, err := encodeRecord(logRecord{
+			Op:         opOffer,
+			ID:         id,
+			Expiration: item.expiration,
+			Value:      value,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, d.path); err != nil {
+		return err
+	}
+	renamed = true
+
+	d.file.Close()
+	d.file = file
+	d.garbage = 0
+
+	// Sync the directory as well, otherwise a crash may lose the rename and
+	// leave the old log behind.
+	return syncDir(filepath.Dir(d.path))
+}
+
+// syncDir syncs the directory at path, so that the changes of its entries
+// survive a crash.
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	err = dir.Sync()
+	if closeErr := dir.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// appendLocked appends rec to the log, must be called with d.mu held.
+func (d *Durable[T]) appendLocked(rec logRecord) error {
+	buf, err := encodeRecord(rec)
+	if err != nil {
+		return err
+	}
+
+	if _, err := d.file.Write(buf); err != nil {
+		return err
+	}
+	if d.syncWrites {
+		return d.file.Sync()
+	}
+
+	return nil
+}
+
+// sortedIDs returns the IDs of the unacknowledged elements in the order they were offered.
+func (d *Durable[T]) sortedIDs() []uint64 {
+	ids := make([]uint64, 0, len(d.items))
+	for id := range d.items {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+
+	return ids
+}
+
+// encodeRecord encodes rec as a header, holding the size and the checksum of
+// the body, followed by the body in JSON.
+func encodeRecord(rec logRecord) ([]byte, error) {
+	body, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, recordHeaderSize+len(body))
+	binary.BigEndian.PutUint32(buf[:4], uint32(len(body)))
+	binary.BigEndian.PutUint32(buf[4:recordHeaderSize], crc32.ChecksumIEEE(body))
+	copy(buf[recordHeaderSize:], body)
+	return buf, nil
+}
+
+// readRecord reads a record from r, and returns it along with its encoded size.
+// It returns io.EOF at the end of r, io.ErrUnexpectedEOF for a torn record,
+// and errCorruptRecord for a corrupted one.
+func readRecord(r io.Reader) (logRecord, int64, error) {
+	var rec logRecord
+	var header [recordHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return rec, 0, err
+	}
+
+	size := binary.BigEndian.Uint32(header[:4])
+	if size > maxRecordSize {
+		return rec, 0, errCorruptRecord
+	}
+
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return rec, 0, err
+	}
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(header[4:]) {
+		return rec, 0, errCorruptRecord
+	}
+	if err := json.Unmarshal(body, &rec); err != nil {
+		return rec, 0, errCorruptRecord
+	}
+
+	return rec, recordHeaderSize + int64(size), nil
+}
@@ -0,0 +1,214 @@
+package delayqueue
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/shanluzhineng/threadingx/timex"
+)
+
+func openDurable(t *testing.T, path string, opts ...Option) *Durable[string] {
+	t.Helper()
+	d, err := OpenDurable[string](path, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// takeAll takes the expired elements of d, and returns them in the order of their IDs.
+func takeAll(d *Durable[string]) (ids []uint64, values []string) {
+	taken := make(map[uint64]string)
+	for {
+		id, value, ok := d.TryTake()
+		if !ok {
+			break
+		}
+		taken[id] = value
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	for _, id := range ids {
+		values = append(values, taken[id])
+	}
+	return ids, values
+}
+
+func TestDurableReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.log")
+	d := openDurable(t, path)
+	a, _ := d.Offer("a", 0)
+	b, _ := d.Offer("b", 0)
+	if _, err := d.Offer("c", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Ack(a); err != nil {
+		t.Fatal(err)
+	}
+	// b is taken but not acknowledged, so it is delivered again after reopening.
+	for {
+		id, _, ok := d.TryTake()
+		if !ok {
+			t.Fatal("b was not taken")
+		}
+		if id == b {
+			break
+		}
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	d = openDurable(t, path)
+	defer d.Close()
+	if n := d.Len(); n != 2 {
+		t.Fatalf("got %d elements after reopening, want 2", n)
+	}
+	if _, values := takeAll(d); len(values) != 2 || values[0] != "b" || values[1] != "c" {
+		t.Fatalf("got %v after reopening, want [b c]", values)
+	}
+	if err := d.Ack(a); err != ErrNotFound {
+		t.Fatalf("got %v acknowledging an acknowledged element, want ErrNotFound", err)
+	}
+}
+
+func TestDurableTruncatesTornRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.log")
+	d := openDurable(t, path)
+	d.Offer("a", 0)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := info.Size()
+	d.Offer("b", 0)
+	d.Close()
+
+	// Tear the last record, like a crash in the middle of its write.
+	if err := os.Truncate(path, valid+recordHeaderSize+3); err != nil {
+		t.Fatal(err)
+	}
+
+	d = openDurable(t, path)
+	if info, _ := os.Stat(path); info.Size() != valid {
+		t.Fatalf("got a log of %d bytes, want it truncated to %d", info.Size(), valid)
+	}
+	if _, values := takeAll(d); len(values) != 1 || values[0] != "a" {
+		t.Fatalf("got %v, want [a]", values)
+	}
+
+	// The log is still usable after the truncation.
+	d.Offer("c", 0)
+	d.Close()
+	d = openDurable(t, path)
+	defer d.Close()
+	if _, values := takeAll(d); len(values) != 2 || values[0] != "a" || values[1] != "c" {
+		t.Fatalf("got %v, want [a c]", values)
+	}
+}
+
+func TestDurableTruncatesCorruptRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.log")
+	d := openDurable(t, path)
+	d.Offer("a", 0)
+	d.Offer("b", 0)
+	d.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Flip a byte in the body of the last record.
+	data[len(data)-2] ^= 0xff
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d = openDurable(t, path)
+	defer d.Close()
+	if _, values := takeAll(d); len(values) != 1 || values[0] != "a" {
+		t.Fatalf("got %v, want [a]", values)
+	}
+}
+
+func TestDurableCompactKeepsIDs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.log")
+	d := openDurable(t, path, WithCompactThreshold(2))
+	a, _ := d.Offer("a", 0)
+	b, _ := d.Offer("b", 0)
+	// Acknowledging both compacts the log down to the last ID.
+	d.Ack(a)
+	d.Ack(b)
+	d.Close()
+
+	d = openDurable(t, path)
+	defer d.Close()
+	c, err := d.Offer("c", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c <= b {
+		t.Fatalf("got ID %d after compaction, want it greater than %d", c, b)
+	}
+}
+
+func TestDurableCompactsPeriodically(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.log")
+	clock := timex.NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	d := openDurable(t, path, WithClock(clock), WithCompactThreshold(1000),
+		WithCompactInterval(time.Minute))
+	a, _ := d.Offer("a", 0)
+	b, _ := d.Offer("b", 0)
+	d.Offer("c", 0)
+	d.Ack(a)
+	d.Ack(b)
+
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Ack alone does not compact the log below the threshold.
+	d.mu.Lock()
+	garbage := d.garbage
+	d.mu.Unlock()
+	if garbage == 0 {
+		t.Fatal("the log was compacted by Ack below the threshold")
+	}
+
+	for clock.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(time.Minute)
+	deadline := time.Now().Add(time.Second)
+	for {
+		d.mu.Lock()
+		garbage = d.garbage
+		d.mu.Unlock()
+		if garbage == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the log was not compacted after the interval")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Size() >= before.Size() {
+		t.Fatalf("got %d bytes after compaction, want less than %d", after.Size(), before.Size())
+	}
+	d.Close()
+
+	d = openDurable(t, path)
+	defer d.Close()
+	if _, values := takeAll(d); len(values) != 1 || values[0] != "c" {
+		t.Fatalf("got %v after reopening, want [c]", values)
+	}
+}
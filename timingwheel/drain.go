@@ -0,0 +1,143 @@
+package timingwheel
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DrainMode decides what StopAndDrain does with the pending timers.
+type DrainMode int
+
+const (
+	// DrainDue fires the pending timers that are already due, and returns the others.
+	DrainDue DrainMode = iota
+	// DrainAll fires all the pending timers, whether they are due or not.
+	DrainAll
+	// DrainNone fires none of the pending timers, and returns them all.
+	DrainNone
+)
+
+// PendingTimer is a timer left unfired by StopAndDrain, which can be used to
+// persist the timer and to schedule it again later.
+type PendingTimer struct {
+	Key        string
+	Expiration time.Time
+	Timer      *Timer
+}
+
+// StopAndDrain stops the current timing wheel, handles the pending timers
+// according to mode, and then waits for the running tasks to complete.
+//
+// It returns the unfired timers ordered by their expirations. If ctx is done
+// before the running tasks complete, it returns ctx.Err() along with them.
+//
+// Once the timing wheel is drained, the timers scheduled by ScheduleFunc are
+// not rescheduled anymore, and the timers added by AfterFunc, PostFunc or
+// Timer.Reset are dropped without being fired. The timers added concurrently
+// with StopAndDrain are either returned or dropped.
+//
+// Calling Stop or StopAndDrain again after StopAndDrain does nothing.
+func (tw *TimingWheel) StopAndDrain(ctx context.Context, mode DrainMode) ([]PendingTimer, error) {
+	tw.exit()
+
+	// Lock out add only after the goroutines of the timing wheel have returned,
+	// since flush calls add with the lock of a bucket held. From now on, no timer
+	// can be added into the buckets being collected.
+	tw.drainLock.Lock()
+	if atomic.LoadInt32(&tw.drained) != 0 {
+		tw.drainLock.Unlock()
+		return nil, tw.tasks.wait(ctx)
+	}
+	atomic.StoreInt32(&tw.drained, 1)
+	tw.drainLock.Unlock()
+
+	var timers []expiredTimer
+	collect := func(t *Timer) {
+		timers = append(timers, expiredTimer{timer: t, expiration: t.expiration})
+		t.setBucket(nil)
+	}
+	for w := tw; w != nil; w = (*TimingWheel)(atomic.LoadPointer(&w.overflowWheel)) {
+		for _, b := range w.buckets {
+			b.Flush(collect)
+		}
+	}
+	if tw.far != nil {
+		tw.far.Flush(collect)
+	}
+	sort.Slice(timers, func(i, j int) bool {
+		return timers[i].expiration < timers[j].expiration
+	})
+
+	now := tw.now()
+	var pending []PendingTimer
+	for _, e := range timers {
+		if mode == DrainAll || mode == DrainDue && e.expiration <= now {
+			tw.run(e.timer, e.expiration)
+			continue
+		}
+
+		// the timer is exposed to the caller from now on, never reuse it.
+		e.timer.pooled = false
+		key := e.timer.GetKey()
+		// the timer never fires, so it must not be found by its key anymore.
+		tw.registry.remove(e.timer, key)
+		pending = append(pending, PendingTimer{
+			Key:        key,
+			Expiration: tw.unitsToWall(e.expiration),
+			Timer:      e.timer,
+		})
+	}
+
+	if tw.pool != nil {
+		tw.pool.stop()
+	}
+
+	return pending, tw.tasks.wait(ctx)
+}
+
+// taskTracker counts the running tasks, and lets the callers wait for them.
+type taskTracker struct {
+	lock    sync.Mutex
+	running int
+	idleC   chan struct{}
+}
+
+func (tt *taskTracker) add() {
+	tt.lock.Lock()
+	tt.running++
+	tt.lock.Unlock()
+}
+
+func (tt *taskTracker) done() {
+	tt.lock.Lock()
+	tt.running--
+	if tt.running == 0 && tt.idleC != nil {
+		close(tt.idleC)
+		tt.idleC = nil
+	}
+	tt.lock.Unlock()
+}
+
+// wait waits until no task is running, or until ctx is done.
+func (tt *taskTracker) wait(ctx context.Context) error {
+	tt.lock.Lock()
+	if tt.running == 0 {
+		tt.lock.Unlock()
+		return nil
+	}
+	if tt.idleC == nil {
+		tt.idleC = make(chan struct{})
+	}
+	idleC := tt.idleC
+	tt.lock.Unlock()
+
+	select {
+	case <-idleC:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,130 @@
+package timingwheel
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shanluzhineng/threadingx/timex"
+)
+
+func TestStopAndDrainReturnsPending(t *testing.T) {
+	clock := timex.NewFakeClock(time.Unix(1000, 0))
+	tw := NewTimingWheel(time.Millisecond, 20, WithClock(clock))
+	tw.Start()
+
+	var fired int32
+	f := func() { atomic.AddInt32(&fired, 1) }
+	tw.AfterFuncWith(time.Hour, "later", f)
+	tw.AfterFuncWith(10*time.Millisecond, "sooner", f)
+
+	pending, err := tw.StopAndDrain(context.Background(), DrainNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 || pending[0].Key != "sooner" || pending[1].Key != "later" {
+		t.Fatalf("got %+v, want the timers sooner and later", pending)
+	}
+	if atomic.LoadInt32(&fired) != 0 {
+		t.Fatal("DrainNone fired a timer")
+	}
+	for _, key := range []string{"sooner", "later"} {
+		if _, ok := tw.Lookup(key); ok || tw.Exists(key) {
+			t.Fatalf("the drained timer %q is still found by its key", key)
+		}
+	}
+
+	// Neither panics nor returns the timers again.
+	tw.Stop()
+	pending, err = tw.StopAndDrain(context.Background(), DrainNone)
+	if err != nil || len(pending) != 0 {
+		t.Fatalf("got %v, %v from the second drain", pending, err)
+	}
+}
+
+func TestStopAndDrainDropsLateTimers(t *testing.T) {
+	tw := NewTimingWheel(time.Millisecond, 20)
+	tw.Start()
+
+	var fired int32
+	f := func() { atomic.AddInt32(&fired, 1) }
+	timer := tw.AfterFunc(time.Hour, f)
+	if _, err := tw.StopAndDrain(context.Background(), DrainNone); err != nil {
+		t.Fatal(err)
+	}
+
+	late := tw.AfterFuncWith(time.Millisecond, "late", f)
+	tw.AfterFunc(0, f)
+	tw.PostFunc(0, f)
+	timer.Reset(0)
+	timer.Reset(time.Millisecond)
+
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadInt32(&fired); n != 0 {
+		t.Fatalf("%d timers fired after the drain", n)
+	}
+	if late.Stop() || timer.Stop() {
+		t.Fatal("a timer is still pending after the drain")
+	}
+	if _, ok := tw.Lookup("late"); ok {
+		t.Fatal("a dropped timer is still registered")
+	}
+}
+
+func TestStopAndDrainConcurrentAdds(t *testing.T) {
+	tw := NewTimingWheel(time.Millisecond, 4)
+	tw.Start()
+
+	var fired int32
+	f := func() { atomic.AddInt32(&fired, 1) }
+	for i := 0; i < 100; i++ {
+		tw.ScheduleFunc(Every(time.Millisecond), f)
+	}
+
+	var (
+		lock   sync.Mutex
+		timers []*Timer
+		wg     sync.WaitGroup
+	)
+	stopC := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; ; j++ {
+				select {
+				case <-stopC:
+					return
+				default:
+				}
+				timer := tw.AfterFunc(time.Duration(j%50)*time.Millisecond, f)
+				lock.Lock()
+				timers = append(timers, timer)
+				lock.Unlock()
+			}
+		}()
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if _, err := tw.StopAndDrain(context.Background(), DrainNone); err != nil {
+		t.Fatal(err)
+	}
+	close(stopC)
+	wg.Wait()
+
+	lock.Lock()
+	defer lock.Unlock()
+	for _, timer := range timers {
+		if timer.getBucket() != nil {
+			t.Fatal("a timer has been added into a bucket after the drain")
+		}
+	}
+
+	n := atomic.LoadInt32(&fired)
+	time.Sleep(20 * time.Millisecond)
+	if atomic.LoadInt32(&fired) != n {
+		t.Fatal("the timers keep firing after the drain")
+	}
+}
@@ -0,0 +1,90 @@
+package timingwheel
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+// addFar adds the timer t, which expires beyond the horizon, into the far bucket.
+func (tw *TimingWheel) addFar(t *Timer) {
+	b := tw.far
+	b.mu.Lock()
+	b.link(t)
+	if t.index == 0 {
+		// t is the earliest one now.
+		tw.scheduleFarLocked()
+	}
+	b.mu.Unlock()
+}
+
+// scheduleFarLocked schedules the far bucket to be flushed once its earliest
+// timer gets within the horizon, must be called with far.mu held.
+func (tw *TimingWheel) scheduleFarLocked() {
+	b := tw.far
+	t := b.first()
+	if t == nil {
+		return
+	}
+
+	move := t.expiration - tw.horizon
+	b.SetExpiration(move)
+	if tw.farHandle == nil || !tw.queue.UpdateDelay(tw.farHandle, move) {
+		// The far bucket has been taken from the queue, offer it again.
+		tw.farHandle = tw.queue.Offer(b, move)
+	}
+}
+
+// flushFar moves the timers of the far bucket that get within the horizon
+// into the timing wheel, and runs the tasks of the expired ones.
+func (tw *TimingWheel) flushFar() {
+	b := tw.far
+	limit := tw.now() + tw.horizon
+	expired := tw.expired
+
+	b.mu.Lock()
+	for t := b.first(); t != nil && t.expiration <= limit; t = b.first() {
+		// Like bucket.Flush, t keeps referring to b until it has been reinserted.
+		b.unlink(t)
+		if !tw.insert(t) {
+			// Already expired
+			expired = append(expired, expiredTimer{timer: t, expiration: t.expiration})
+			t.setBucket(nil)
+		}
+	}
+	tw.scheduleFarLocked()
+	b.mu.Unlock()
+
+	tw.runExpired(expired)
+}
+
+// reclaim detaches the overflow wheels above the highest level holding timers,
+// if they were found idle by the previous call too, so that they can be freed.
+// It returns the lowest idle overflow wheel, which is passed to the next call.
+//
+// The goroutine of the timing wheel adds timers into the overflow wheels while
+// holding the lock of a bucket being flushed, so reclaim must neither wait for
+// the levels, which would block the later readers, nor lock any bucket.
+func (tw *TimingWheel) reclaim(idle *TimingWheel) *TimingWheel {
+	if !tw.levels.TryLock() {
+		// Timers are being added, try again next time.
+		return idle
+	}
+	defer tw.levels.Unlock()
+
+	keep := tw
+	for w := tw; w != nil; w = (*TimingWheel)(atomic.LoadPointer(&w.overflowWheel)) {
+		if atomic.LoadInt32(&w.pending) > 0 {
+			keep = w
+		}
+	}
+
+	next := (*TimingWheel)(atomic.LoadPointer(&keep.overflowWheel))
+	if next != nil && next == idle {
+		// The buckets of the detached wheels may still be in the queue,
+		// they are flushed as usual once expired.
+		atomic.StorePointer(&keep.overflowWheel, unsafe.Pointer(nil))
+		return nil
+	}
+
+	return next
+}
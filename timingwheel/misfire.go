@@ -0,0 +1,53 @@
+package timingwheel
+
+// MisfirePolicy decides what a timer scheduled by ScheduleFunc does with the
+// runs it missed while being late, e.g. after a long GC pause or a blocked
+// timing wheel.
+type MisfirePolicy int
+
+const (
+	// MisfireFireAll fires all the missed runs one after another, to catch up.
+	MisfireFireAll MisfirePolicy = iota
+	// MisfireCoalesce fires the missed runs as a single run, and then
+	// resumes from the next time in the future.
+	MisfireCoalesce
+	// MisfireSkip skips the missed runs, including the late one, and then
+	// resumes from the next time in the future.
+	MisfireSkip
+)
+
+type (
+	// ScheduleOption defines the method to customize a timer scheduled by ScheduleFunc.
+	ScheduleOption func(options *scheduleOptions)
+
+	scheduleOptions struct {
+		misfirePolicy MisfirePolicy
+		onMisfire     func(missed int)
+	}
+)
+
+// WithMisfirePolicy customizes a scheduled timer with the given misfire policy.
+// The default policy is MisfireFireAll.
+func WithMisfirePolicy(policy MisfirePolicy) ScheduleOption {
+	return func(options *scheduleOptions) {
+		options.misfirePolicy = policy
+	}
+}
+
+// WithMisfireHandler customizes a scheduled timer with the handler that is
+// called with the number of the missed runs, each time the MisfireCoalesce or
+// MisfireSkip policy drops some runs. It is called in the goroutine of the task.
+func WithMisfireHandler(fn func(missed int)) ScheduleOption {
+	return func(options *scheduleOptions) {
+		options.onMisfire = fn
+	}
+}
+
+func newScheduleOptions(opts []ScheduleOption) scheduleOptions {
+	var options scheduleOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	return options
+}
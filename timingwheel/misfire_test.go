@@ -0,0 +1,57 @@
+package timingwheel
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shanluzhineng/threadingx/timex"
+)
+
+func TestMisfirePolicies(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy MisfirePolicy
+		// The runs and the missed runs reported after being late by 4 periods.
+		runs, missed int32
+	}{
+		{name: "fire all", policy: MisfireFireAll, runs: 5, missed: 0},
+		{name: "coalesce", policy: MisfireCoalesce, runs: 1, missed: 4},
+		{name: "skip", policy: MisfireSkip, runs: 0, missed: 5},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock := timex.NewFakeClock(time.Unix(1000, 0))
+			tw := NewTimingWheel(time.Millisecond, 20, WithClock(clock))
+			tw.Start()
+			defer tw.Stop()
+
+			var runs, missed int32
+			tw.ScheduleFunc(Every(10*time.Millisecond), func() {
+				atomic.AddInt32(&runs, 1)
+			}, WithMisfirePolicy(test.policy), WithMisfireHandler(func(n int) {
+				atomic.AddInt32(&missed, int32(n))
+			}))
+
+			// Due at 10ms, 20ms, 30ms, 40ms and 50ms.
+			advanceIdle(t, clock, 55*time.Millisecond)
+			waitFor(t, func() bool {
+				return atomic.LoadInt32(&runs) == test.runs && atomic.LoadInt32(&missed) == test.missed
+			})
+			time.Sleep(10 * time.Millisecond)
+			if n, m := atomic.LoadInt32(&runs), atomic.LoadInt32(&missed); n != test.runs || m != test.missed {
+				t.Fatalf("got %d runs and %d missed, want %d and %d", n, m, test.runs, test.missed)
+			}
+
+			// Back on schedule, the run at 60ms is not late.
+			advanceIdle(t, clock, 10*time.Millisecond)
+			waitFor(t, func() bool {
+				return atomic.LoadInt32(&runs) == test.runs+1
+			})
+			if m := atomic.LoadInt32(&missed); m != test.missed {
+				t.Fatalf("got %d missed after being back on schedule, want %d", m, test.missed)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package timingwheel
+
+import (
+	"errors"
+	"time"
+
+	"github.com/shanluzhineng/threadingx/timex"
+)
+
+type (
+	// Option defines the method to customize a TimingWheel.
+	Option func(options *options)
+
+	options struct {
+		clock timex.Clock
+		unit  time.Duration
+
+		horizon time.Duration
+
+		workers        int
+		queueSize      int
+		overflowPolicy OverflowPolicy
+		onDrop         func(*Timer)
+		onPanic        func(p interface{}, stack []byte, key string)
+
+		hooks Hooks
+
+		duplicateKeyPolicy DuplicateKeyPolicy
+	}
+)
+
+// WithClock customizes a TimingWheel with the given clock, which is used to
+// compute the expirations of the timers and to wait for them to expire.
+// Use a timex.FakeClock to drive the timing wheel manually in tests.
+func WithClock(clock timex.Clock) Option {
+	return func(options *options) {
+		options.clock = clock
+	}
+}
+
+// WithTimeUnit customizes a TimingWheel with the unit of the times it keeps,
+// which bounds its resolution, e.g. time.Microsecond for the ticks under 1ms.
+// The default unit is time.Millisecond.
+func WithTimeUnit(unit time.Duration) Option {
+	if unit <= 0 {
+		panic(errors.New("unit must be greater than 0"))
+	}
+
+	return func(options *options) {
+		options.unit = unit
+	}
+}
+
+// WithHorizon customizes a TimingWheel to keep the timers expiring beyond
+// the horizon in a heap, instead of in the overflow wheels created for them,
+// and to move them into the wheels once they get within the horizon.
+func WithHorizon(horizon time.Duration) Option {
+	if horizon <= 0 {
+		panic(errors.New("horizon must be greater than 0"))
+	}
+
+	return func(options *options) {
+		options.horizon = horizon
+	}
+}
+
+// WithWorkerPool customizes a TimingWheel to run the tasks of the expired
+// timers on a pool of the given number of workers, instead of a goroutine
+// per task. At most queueSize tasks wait for a free worker, and the policy
+// decides what to do with the tasks beyond. The tasks are run panic-safe.
+//
+// The workers are started by TimingWheel.Start, and exit after running the
+// queued tasks once TimingWheel.Stop is called.
+func WithWorkerPool(workers, queueSize int, policy OverflowPolicy) Option {
+	if workers <= 0 {
+		panic(errors.New("workers must be greater than 0"))
+	}
+	if queueSize < 0 {
+		panic(errors.New("queueSize must not be negative"))
+	}
+
+	return func(options *options) {
+		options.workers = workers
+		options.queueSize = queueSize
+		options.overflowPolicy = policy
+	}
+}
+
+// WithDropHandler customizes a TimingWheel with the handler that is called
+// with the timers whose tasks are dropped by the OverflowDrop policy.
+func WithDropHandler(fn func(t *Timer)) Option {
+	return func(options *options) {
+		options.onDrop = fn
+	}
+}
+
+// WithPanicHandler customizes a TimingWheel to recover from the panics of the
+// tasks, and to report them to the handler along with the stacks and the keys
+// of the timers, so that a bad task cannot crash the process. The handler is
+// called in the goroutine of the task.
+func WithPanicHandler(fn func(p interface{}, stack []byte, key string)) Option {
+	return func(options *options) {
+		options.onPanic = fn
+	}
+}
+
+// WithHooks customizes a TimingWheel with the hooks called on its events.
+func WithHooks(hooks Hooks) Option {
+	return func(options *options) {
+		options.hooks = hooks
+	}
+}
+
+// WithDuplicateKeyPolicy customizes a TimingWheel with the policy applied
+// when a timer is added with the same key as a pending timer.
+// The default policy is DuplicateAllow.
+func WithDuplicateKeyPolicy(policy DuplicateKeyPolicy) Option {
+	return func(options *options) {
+		options.duplicateKeyPolicy = policy
+	}
+}
+
+func newOptions() options {
+	return options{
+		clock: timex.NewClock(),
+		unit:  time.Millisecond,
+	}
+}
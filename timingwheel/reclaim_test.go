@@ -0,0 +1,91 @@
+package timingwheel
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shanluzhineng/threadingx/timex"
+)
+
+func TestReclaimDuringCascade(t *testing.T) {
+	clock := timex.NewFakeClock(time.Unix(1000, 0))
+	tw := NewTimingWheel(time.Millisecond, 4, WithClock(clock))
+	tw.Start()
+
+	// Reclaim the overflow wheels while the timers cascade down through them.
+	done := make(chan struct{})
+	go func() {
+		var idle *TimingWheel
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				idle = tw.reclaim(idle)
+			}
+		}
+	}()
+
+	const n = 50000
+	var fired int32
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		for round := 1; round <= 5; round++ {
+			for i := 0; i < n; i++ {
+				tw.PostFunc(time.Duration(48+i%16)*time.Millisecond, func() {
+					atomic.AddInt32(&fired, 1)
+				})
+			}
+			for i := 0; i < 64; i++ {
+				clock.Advance(time.Millisecond)
+			}
+			for atomic.LoadInt32(&fired) != int32(round*n) {
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(30 * time.Second):
+		// Do not stop the timing wheel, which would hang if deadlocked.
+		t.Fatalf("deadlocked after firing %d timers", atomic.LoadInt32(&fired))
+	}
+
+	close(done)
+	tw.Stop()
+}
+
+func TestReclaimIdleOverflowWheels(t *testing.T) {
+	clock := timex.NewFakeClock(time.Unix(1000, 0))
+	tw := NewTimingWheel(time.Millisecond, 20, WithClock(clock))
+	tw.Start()
+	defer tw.Stop()
+
+	timer := tw.AfterFunc(48*time.Hour, func() {})
+	if levels := len(tw.Stats().Levels); levels < 2 {
+		t.Fatalf("got %d levels, want overflow wheels", levels)
+	}
+
+	// The overflow wheels are still in use.
+	if idle := tw.reclaim(nil); idle != nil {
+		t.Fatal("reclaim returned an idle wheel while the timer is pending")
+	}
+
+	timer.Stop()
+	idle := tw.reclaim(nil)
+	if idle == nil {
+		t.Fatal("reclaim did not find the idle overflow wheels")
+	}
+	if levels := len(tw.Stats().Levels); levels < 2 {
+		t.Fatal("the overflow wheels are detached at the first sight")
+	}
+	if tw.reclaim(idle) != nil {
+		t.Fatal("reclaim returned an idle wheel after detaching")
+	}
+	if levels := len(tw.Stats().Levels); levels != 1 {
+		t.Fatalf("got %d levels after reclaiming, want 1", levels)
+	}
+}
@@ -0,0 +1,169 @@
+package timingwheel
+
+import "sync"
+
+// DuplicateKeyPolicy decides what to do when a timer is added to a TimingWheel
+// with the same key as a pending timer.
+type DuplicateKeyPolicy int
+
+const (
+	// DuplicateAllow keeps all the timers with the same key,
+	// Lookup returns the latest one.
+	DuplicateAllow DuplicateKeyPolicy = iota
+	// DuplicateReject rejects the new timer.
+	DuplicateReject
+	// DuplicateReplace stops the pending timers and keeps the new one.
+	DuplicateReplace
+)
+
+// registry indexes the pending timers of a TimingWheel by their keys.
+type registry struct {
+	policy DuplicateKeyPolicy
+
+	lock   sync.RWMutex
+	timers map[string][]*Timer
+}
+
+func newRegistry(policy DuplicateKeyPolicy) *registry {
+	return &registry{
+		policy: policy,
+		timers: make(map[string][]*Timer),
+	}
+}
+
+// add indexes t with key according to the policy, and returns false if t is rejected.
+func (r *registry) add(t *Timer, key string) bool {
+	ok, replaced := r.index(t, key)
+	stopTimers(replaced)
+	return ok
+}
+
+// index is like add, but returns the timers replaced by t instead of stopping
+// them, so that the caller holding the lock of t can stop them after releasing it.
+func (r *registry) index(t *Timer, key string) (bool, []*Timer) {
+	if len(key) == 0 {
+		return true, nil
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	timers := r.timers[key]
+	for _, each := range timers {
+		if each == t {
+			return true, nil
+		}
+	}
+
+	var replaced []*Timer
+	if len(timers) > 0 {
+		switch r.policy {
+		case DuplicateReject:
+			return false, nil
+		case DuplicateReplace:
+			replaced = timers
+			timers = nil
+		}
+	}
+	r.timers[key] = append(timers, t)
+	return true, replaced
+}
+
+// stopTimers stops the timers replaced by index without holding the lock of
+// the registry, since stopping a timer removes it from the registry.
+func stopTimers(timers []*Timer) {
+	for _, t := range timers {
+		t.Stop()
+	}
+}
+
+// remove removes t from the index of key.
+func (r *registry) remove(t *Timer, key string) {
+	if len(key) == 0 {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.removeLocked(t, key)
+}
+
+// removeIfIdle removes t from the index of key, unless t has been added back
+// to the timing wheel in the meantime, e.g. by a concurrent Timer.Reset.
+func (r *registry) removeIfIdle(t *Timer, key string) {
+	if len(key) == 0 {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if t.getBucket() == nil {
+		r.removeLocked(t, key)
+	}
+}
+
+func (r *registry) removeLocked(t *Timer, key string) {
+	timers := r.timers[key]
+	for i, each := range timers {
+		if each != t {
+			continue
+		}
+
+		if len(timers) == 1 {
+			delete(r.timers, key)
+		} else {
+			// keep the order of the timers, so that the latest one stays at the end
+			copy(timers[i:], timers[i+1:])
+			timers[len(timers)-1] = nil
+			r.timers[key] = timers[:len(timers)-1]
+		}
+		return
+	}
+}
+
+// lookup returns the latest timer indexed with key.
+func (r *registry) lookup(key string) (*Timer, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	timers := r.timers[key]
+	if len(timers) == 0 {
+		return nil, false
+	}
+	return timers[len(timers)-1], true
+}
+
+// all returns the timers indexed with key.
+func (r *registry) all(key string) []*Timer {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	timers := make([]*Timer, len(r.timers[key]))
+	copy(timers, r.timers[key])
+	return timers
+}
+
+// Lookup returns the pending timer with the given key. If there are several
+// timers with the key, the latest added one is returned.
+func (tw *TimingWheel) Lookup(key string) (*Timer, bool) {
+	return tw.registry.lookup(key)
+}
+
+// Exists checks if there is any pending timer with the given key.
+func (tw *TimingWheel) Exists(key string) bool {
+	_, ok := tw.registry.lookup(key)
+	return ok
+}
+
+// StopByKey stops all the pending timers with the given key.
+// It returns true if any timer is stopped.
+func (tw *TimingWheel) StopByKey(key string) bool {
+	stopped := false
+	for _, t := range tw.registry.all(key) {
+		if t.Stop() {
+			stopped = true
+		}
+	}
+
+	return stopped
+}
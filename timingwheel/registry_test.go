@@ -0,0 +1,150 @@
+package timingwheel
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestResetRejectedKey(t *testing.T) {
+	tw := NewTimingWheel(time.Millisecond, 20, WithDuplicateKeyPolicy(DuplicateReject))
+	tw.Start()
+	defer tw.Stop()
+
+	var fired int32
+	old := tw.AfterFuncWith(time.Hour, "key", func() { atomic.AddInt32(&fired, 1) })
+	old.Stop()
+	current := tw.AfterFuncWith(time.Hour, "key", func() {})
+	if current == nil {
+		t.Fatal("the key of a stopped timer is still taken")
+	}
+
+	if old.Reset(time.Millisecond) {
+		t.Fatal("Reset reported a stopped timer as active")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if atomic.LoadInt32(&fired) != 0 {
+		t.Fatal("a timer with a rejected key has been scheduled again")
+	}
+	if got, _ := tw.Lookup("key"); got != current {
+		t.Fatal("the key does not index the pending timer")
+	}
+}
+
+func TestSetKeyRejected(t *testing.T) {
+	tw := NewTimingWheel(time.Millisecond, 20, WithDuplicateKeyPolicy(DuplicateReject))
+	tw.Start()
+	defer tw.Stop()
+
+	a := tw.AfterFuncWith(time.Hour, "a", func() {})
+	b := tw.AfterFuncWith(time.Hour, "b", func() {})
+	a.SetKey("b")
+	if a.GetKey() != "a" {
+		t.Fatalf("got the key %q, want the rejected key to be ignored", a.GetKey())
+	}
+	if got, _ := tw.Lookup("a"); got != a {
+		t.Fatal("the timer is not indexed by its old key anymore")
+	}
+	if got, _ := tw.Lookup("b"); got != b {
+		t.Fatal("the key has been taken from the pending timer")
+	}
+}
+
+func TestResetKeepsNoStaleKey(t *testing.T) {
+	tw := NewTimingWheel(time.Millisecond, 20, WithDuplicateKeyPolicy(DuplicateReject))
+	tw.Start()
+	defer tw.Stop()
+
+	firedC := make(chan struct{}, 1)
+	timer := tw.AfterFuncWith(0, "key", func() { firedC <- struct{}{} })
+	for i := 0; i < 200; i++ {
+		<-firedC
+		// Fires right away, racing with the indexing of the key.
+		timer.Reset(0)
+	}
+	<-firedC
+
+	waitFor(t, func() bool {
+		return !tw.Exists("key")
+	})
+	if tw.AfterFuncWith(time.Hour, "key", func() {}) == nil {
+		t.Fatal("the key is still taken by a fired timer")
+	}
+}
+
+func TestDuplicateKeyPolicies(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy DuplicateKeyPolicy
+		// Whether the second timer is accepted, and whether the first one
+		// keeps pending along with it.
+		accepted, firstPending bool
+		// The number of the timers indexed by the key.
+		indexed int
+	}{
+		{name: "allow", policy: DuplicateAllow, accepted: true, firstPending: true, indexed: 2},
+		{name: "reject", policy: DuplicateReject, accepted: false, firstPending: true, indexed: 1},
+		{name: "replace", policy: DuplicateReplace, accepted: true, firstPending: false, indexed: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var stopped int32
+			tw := NewTimingWheel(time.Millisecond, 20, WithDuplicateKeyPolicy(test.policy),
+				WithHooks(Hooks{
+					OnStop: func(t *Timer) { atomic.AddInt32(&stopped, 1) },
+				}))
+			tw.Start()
+			defer tw.Stop()
+
+			first := tw.AfterFuncWith(time.Hour, "key", func() {})
+			second := tw.AfterFuncWith(time.Hour, "key", func() {})
+			if (second != nil) != test.accepted {
+				t.Fatalf("got the second timer %v, want accepted %v", second, test.accepted)
+			}
+
+			latest, ok := tw.Lookup("key")
+			if !ok {
+				t.Fatal("no timer is found by the key")
+			}
+			if test.accepted && latest != second || !test.accepted && latest != first {
+				t.Fatal("Lookup does not return the latest accepted timer")
+			}
+			if n := len(tw.registry.all("key")); n != test.indexed {
+				t.Fatalf("got %d timers indexed by the key, want %d", n, test.indexed)
+			}
+
+			// Stop returns false if the first timer has been stopped by the replacement.
+			if first.Stop() != test.firstPending {
+				t.Fatalf("got the first timer pending %v, want %v", !test.firstPending, test.firstPending)
+			}
+			if !test.firstPending && atomic.LoadInt32(&stopped) != 1 {
+				t.Fatal("the replaced timer is not reported as stopped")
+			}
+		})
+	}
+}
+
+func TestStopByKey(t *testing.T) {
+	tw := NewTimingWheel(time.Millisecond, 20)
+	tw.Start()
+	defer tw.Stop()
+
+	timers := []*Timer{
+		tw.AfterFuncWith(time.Hour, "key", func() {}),
+		tw.AfterFuncWith(time.Hour, "key", func() {}),
+	}
+	other := tw.AfterFuncWith(time.Hour, "other", func() {})
+
+	if !tw.StopByKey("key") || tw.StopByKey("key") {
+		t.Fatal("StopByKey should stop the timers only once")
+	}
+	for _, timer := range timers {
+		if timer.Stop() {
+			t.Fatal("a timer with the key is still pending")
+		}
+	}
+	if tw.Exists("key") || !tw.Exists("other") || !other.Stop() {
+		t.Fatal("StopByKey affected the timers of another key")
+	}
+}
@@ -0,0 +1,344 @@
+package timingwheel
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// maxSearch is the maximum number of the times asked to the underlying
+// schedulers when searching for a time accepted by Window or Intersect.
+const maxSearch = 1 << 20
+
+type (
+	backoffScheduler struct {
+		max    time.Duration
+		factor float64
+
+		lock  sync.Mutex
+		delay time.Duration
+	}
+
+	jitterScheduler struct {
+		scheduler Scheduler
+		max       time.Duration
+
+		lock sync.Mutex
+		// base is the last time returned by scheduler, and last is base with jitter.
+		base time.Time
+		last time.Time
+	}
+
+	limitScheduler struct {
+		scheduler Scheduler
+
+		lock sync.Mutex
+		left int
+	}
+
+	untilScheduler struct {
+		scheduler Scheduler
+		deadline  time.Time
+	}
+
+	windowScheduler struct {
+		scheduler  Scheduler
+		start, end time.Duration
+		location   *time.Location
+	}
+
+	unionScheduler struct {
+		schedulers []Scheduler
+
+		lock  sync.Mutex
+		nexts []time.Time
+		done  []bool
+	}
+
+	intersectScheduler struct {
+		schedulers []Scheduler
+	}
+)
+
+// RelativeScheduler is implemented by the Schedulers whose times are relative
+// to the previous ones, like Every, rather than absolute wall-clock times, like
+// the cron schedules. The timers of the Schedulers whose Relative returns true
+// follow the monotonic clock, so that their intervals are kept when the wall
+// clock jumps, while the timers of the other Schedulers follow the wall clock.
+type RelativeScheduler interface {
+	Scheduler
+	Relative() bool
+}
+
+// isRelative checks if the times of s are relative to the previous ones.
+func isRelative(s Scheduler) bool {
+	r, ok := s.(RelativeScheduler)
+	return ok && r.Relative()
+}
+
+// Backoff returns a Scheduler whose intervals start from initial, and grow
+// by factor after each run, up to max. The Scheduler is stateful, so it
+// must not be shared by several timers.
+func Backoff(initial, max time.Duration, factor float64) Scheduler {
+	if initial <= 0 {
+		panic(errors.New("initial must be greater than 0"))
+	}
+	if max < initial {
+		panic(errors.New("max must not be less than initial"))
+	}
+	if factor < 1 {
+		panic(errors.New("factor must not be less than 1"))
+	}
+
+	return &backoffScheduler{
+		max:    max,
+		factor: factor,
+		delay:  initial,
+	}
+}
+
+func (s *backoffScheduler) Next(prev time.Time) time.Time {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delay := s.delay
+	if next := time.Duration(float64(s.delay) * s.factor); next < s.max {
+		s.delay = next
+	} else {
+		s.delay = s.max
+	}
+
+	return prev.Add(delay).UTC()
+}
+
+func (s *backoffScheduler) Relative() bool {
+	return true
+}
+
+// Jitter returns a Scheduler that delays each time of s by a random duration
+// in [0, max). The jitter does not accumulate: s is always asked with its own
+// previous time. The Scheduler is stateful, so it must not be shared by several timers.
+func Jitter(s Scheduler, max time.Duration) Scheduler {
+	if max <= 0 {
+		panic(errors.New("max must be greater than 0"))
+	}
+
+	return &jitterScheduler{
+		scheduler: s,
+		max:       max,
+	}
+}
+
+func (s *jitterScheduler) Next(prev time.Time) time.Time {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.last.IsZero() && prev.Equal(s.last) {
+		prev = s.base
+	}
+
+	next := s.scheduler.Next(prev)
+	if next.IsZero() {
+		return next
+	}
+
+	s.base = next
+	s.last = next.Add(time.Duration(rand.Int63n(int64(s.max)))).UTC()
+	return s.last
+}
+
+func (s *jitterScheduler) Relative() bool {
+	return isRelative(s.scheduler)
+}
+
+// Limit returns a Scheduler that runs s at most n times. The Scheduler is
+// stateful, so it must not be shared by several timers.
+func Limit(s Scheduler, n int) Scheduler {
+	return &limitScheduler{
+		scheduler: s,
+		left:      n,
+	}
+}
+
+func (s *limitScheduler) Next(prev time.Time) time.Time {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.left <= 0 {
+		return time.Time{}
+	}
+
+	next := s.scheduler.Next(prev)
+	if !next.IsZero() {
+		s.left--
+	}
+	return next
+}
+
+func (s *limitScheduler) Relative() bool {
+	return isRelative(s.scheduler)
+}
+
+// Until returns a Scheduler that runs s until the deadline, exclusively.
+func Until(s Scheduler, deadline time.Time) Scheduler {
+	return untilScheduler{
+		scheduler: s,
+		deadline:  deadline,
+	}
+}
+
+func (s untilScheduler) Next(prev time.Time) time.Time {
+	next := s.scheduler.Next(prev)
+	if next.IsZero() || !next.Before(s.deadline) {
+		return time.Time{}
+	}
+
+	return next
+}
+
+func (s untilScheduler) Relative() bool {
+	return isRelative(s.scheduler)
+}
+
+// Window returns a Scheduler that only keeps the times of s within the daily
+// window [start, end) in the given location, where start and end are the
+// offsets since midnight, e.g. 9*time.Hour and 18*time.Hour. If start is
+// after end, the window spans midnight. It panics if start equals end, or
+// if loc is nil.
+//
+// The times of s outside the window are skipped, so s should be stateless.
+func Window(s Scheduler, start, end time.Duration, loc *time.Location) Scheduler {
+	const day = 24 * time.Hour
+	if start < 0 || start >= day || end < 0 || end > day {
+		panic(errors.New("start and end must be within a day"))
+	}
+	if start == end {
+		panic(errors.New("start must not equal end"))
+	}
+	if loc == nil {
+		panic(errors.New("loc must not be nil"))
+	}
+
+	return windowScheduler{
+		scheduler: s,
+		start:     start,
+		end:       end,
+		location:  loc,
+	}
+}
+
+func (s windowScheduler) Next(prev time.Time) time.Time {
+	next := s.scheduler.Next(prev)
+	for i := 0; i < maxSearch && !next.IsZero(); i++ {
+		if s.contains(next) {
+			return next
+		}
+		next = s.scheduler.Next(next)
+	}
+
+	return time.Time{}
+}
+
+func (s windowScheduler) contains(t time.Time) bool {
+	t = t.In(s.location)
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond())
+	if s.start <= s.end {
+		return s.start <= offset && offset < s.end
+	}
+
+	return offset >= s.start || offset < s.end
+}
+
+// Union returns a Scheduler that runs at the times of any of the schedulers.
+// The Scheduler is stateful, so it must not be shared by several timers.
+func Union(schedulers ...Scheduler) Scheduler {
+	return &unionScheduler{
+		schedulers: schedulers,
+		nexts:      make([]time.Time, len(schedulers)),
+		done:       make([]bool, len(schedulers)),
+	}
+}
+
+func (s *unionScheduler) Next(prev time.Time) time.Time {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var next time.Time
+	for i, scheduler := range s.schedulers {
+		if s.done[i] {
+			continue
+		}
+
+		// Only ask the schedulers whose pending times have been reached,
+		// so that the stateful ones are asked once for each of their times.
+		if s.nexts[i].IsZero() || !s.nexts[i].After(prev) {
+			s.nexts[i] = scheduler.Next(prev)
+			if s.nexts[i].IsZero() {
+				s.done[i] = true
+				continue
+			}
+		}
+
+		if next.IsZero() || s.nexts[i].Before(next) {
+			next = s.nexts[i]
+		}
+	}
+
+	return next
+}
+
+func (s *unionScheduler) Relative() bool {
+	for _, scheduler := range s.schedulers {
+		if !isRelative(scheduler) {
+			return false
+		}
+	}
+
+	return len(s.schedulers) > 0
+}
+
+// Intersect returns a Scheduler that only runs at the times shared by all the
+// schedulers. The schedulers are searched for the shared times, so they should
+// be stateless and absolute, like the cron schedules.
+func Intersect(schedulers ...Scheduler) Scheduler {
+	return intersectScheduler{
+		schedulers: schedulers,
+	}
+}
+
+func (s intersectScheduler) Next(prev time.Time) time.Time {
+	if len(s.schedulers) == 0 {
+		return time.Time{}
+	}
+
+	from := prev
+	for i := 0; i < maxSearch; i++ {
+		var candidate time.Time
+		agreed := true
+		for _, scheduler := range s.schedulers {
+			next := scheduler.Next(from)
+			if next.IsZero() {
+				return next
+			}
+
+			if candidate.IsZero() {
+				candidate = next
+			} else if !next.Equal(candidate) {
+				agreed = false
+				if next.After(candidate) {
+					candidate = next
+				}
+			}
+		}
+		if agreed {
+			return candidate
+		}
+
+		// No time before the latest candidate is shared, search from it.
+		from = candidate.Add(-time.Nanosecond)
+	}
+
+	return time.Time{}
+}
@@ -0,0 +1,70 @@
+package timingwheel
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindow(t *testing.T) {
+	loc := time.FixedZone("UTC+8", 8*60*60)
+	tests := []struct {
+		name       string
+		start, end time.Duration
+		prev       time.Time
+		want       time.Time
+	}{
+		{
+			name:  "inside",
+			start: 9 * time.Hour,
+			end:   18 * time.Hour,
+			prev:  time.Date(2024, 1, 1, 10, 0, 0, 0, loc),
+			want:  time.Date(2024, 1, 1, 11, 0, 0, 0, loc),
+		},
+		{
+			name:  "skips to the next window",
+			start: 9 * time.Hour,
+			end:   18 * time.Hour,
+			prev:  time.Date(2024, 1, 1, 17, 0, 0, 0, loc),
+			want:  time.Date(2024, 1, 2, 9, 0, 0, 0, loc),
+		},
+		{
+			name:  "spans midnight",
+			start: 22 * time.Hour,
+			end:   2 * time.Hour,
+			prev:  time.Date(2024, 1, 1, 23, 0, 0, 0, loc),
+			want:  time.Date(2024, 1, 2, 0, 0, 0, 0, loc),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := Window(Every(time.Hour), test.start, test.end, loc)
+			if got := s.Next(test.prev.UTC()); !got.Equal(test.want) {
+				t.Fatalf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWindowRejectsInvalidArgs(t *testing.T) {
+	tests := []struct {
+		name       string
+		start, end time.Duration
+		loc        *time.Location
+	}{
+		{name: "start equals end", start: 9 * time.Hour, end: 9 * time.Hour, loc: time.UTC},
+		{name: "nil location", start: 9 * time.Hour, end: 18 * time.Hour},
+		{name: "beyond a day", start: 9 * time.Hour, end: 25 * time.Hour, loc: time.UTC},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("Window did not panic")
+				}
+			}()
+			Window(Every(time.Hour), test.start, test.end, test.loc)
+		})
+	}
+}
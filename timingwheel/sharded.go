@@ -0,0 +1,161 @@
+package timingwheel
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	fnvOffset32 = 2166136261
+	fnvPrime32  = 16777619
+)
+
+// ShardedTimingWheel spreads the timers over several independent TimingWheels,
+// each with its own delay queue and goroutine, so that adding and firing the
+// timers scale across the cores.
+//
+// The timers with keys are placed by the hash of their keys, so that all the
+// timers with the same key are in the same shard, and the key-based methods
+// work as on a single TimingWheel. The others are placed round-robin.
+type ShardedTimingWheel struct {
+	shards []*TimingWheel
+	next   uint32
+}
+
+// NewShardedTimingWheel creates an instance of ShardedTimingWheel with the given
+// number of shards, each created by NewTimingWheel with tick, wheelSize and opts.
+func NewShardedTimingWheel(shards int, tick time.Duration, wheelSize int64, opts ...Option) *ShardedTimingWheel {
+	if shards <= 0 {
+		panic(errors.New("shards must be greater than 0"))
+	}
+
+	stw := &ShardedTimingWheel{
+		shards: make([]*TimingWheel, shards),
+	}
+	for i := range stw.shards {
+		stw.shards[i] = NewTimingWheel(tick, wheelSize, opts...)
+	}
+	return stw
+}
+
+// Start starts all the shards.
+func (stw *ShardedTimingWheel) Start() {
+	for _, tw := range stw.shards {
+		tw.Start()
+	}
+}
+
+// Stop stops all the shards. Please see TimingWheel.Stop for the details.
+func (stw *ShardedTimingWheel) Stop() {
+	for _, tw := range stw.shards {
+		tw.Stop()
+	}
+}
+
+// AfterFunc waits for the duration to elapse and then calls f in its own goroutine.
+// Please see TimingWheel.AfterFunc for the details.
+func (stw *ShardedTimingWheel) AfterFunc(d time.Duration, f func()) *Timer {
+	return stw.shard("").AfterFunc(d, f)
+}
+
+// AfterFuncWith is like AfterFunc but sets the given key to the returned Timer.
+// Please see TimingWheel.AfterFuncWith for the details.
+func (stw *ShardedTimingWheel) AfterFuncWith(d time.Duration, key string, f func()) *Timer {
+	return stw.shard(key).AfterFuncWith(d, key, f)
+}
+
+// ScheduleFunc calls f (in its own goroutine) according to the execution plan
+// scheduled by s. Please see TimingWheel.ScheduleFunc for the details.
+func (stw *ShardedTimingWheel) ScheduleFunc(s Scheduler, f func(), opts ...ScheduleOption) *Timer {
+	return stw.shard("").ScheduleFunc(s, f, opts...)
+}
+
+// ScheduleFuncWith is like ScheduleFunc but sets the given key to the returned Timer.
+// Please see TimingWheel.ScheduleFuncWith for the details.
+func (stw *ShardedTimingWheel) ScheduleFuncWith(s Scheduler, key string, f func(),
+	opts ...ScheduleOption) *Timer {
+	return stw.shard(key).ScheduleFuncWith(s, key, f, opts...)
+}
+
+// Lookup returns the pending timer with the given key.
+// Please see TimingWheel.Lookup for the details.
+func (stw *ShardedTimingWheel) Lookup(key string) (*Timer, bool) {
+	return stw.shard(key).Lookup(key)
+}
+
+// Exists checks if there is any pending timer with the given key.
+func (stw *ShardedTimingWheel) Exists(key string) bool {
+	return stw.shard(key).Exists(key)
+}
+
+// StopByKey stops all the pending timers with the given key.
+// It returns true if any timer is stopped.
+func (stw *ShardedTimingWheel) StopByKey(key string) bool {
+	return stw.shard(key).StopByKey(key)
+}
+
+// Stats returns a snapshot of the statistics of all the shards. The counters
+// and the levels are summed up, while the lag percentiles are the worst ones
+// of the shards, since the samples are kept per shard.
+func (stw *ShardedTimingWheel) Stats() Stats {
+	var stats Stats
+	for _, tw := range stw.shards {
+		shard := tw.Stats()
+		stats.Pending += shard.Pending
+		stats.Far += shard.Far
+		stats.Fired += shard.Fired
+		stats.Stopped += shard.Stopped
+		stats.Dropped += shard.Dropped
+
+		for i, level := range shard.Levels {
+			if i < len(stats.Levels) {
+				stats.Levels[i].Pending += level.Pending
+				stats.Levels[i].Buckets += level.Buckets
+			} else {
+				stats.Levels = append(stats.Levels, level)
+			}
+		}
+
+		stats.Lag.P50 = maxDuration(stats.Lag.P50, shard.Lag.P50)
+		stats.Lag.P90 = maxDuration(stats.Lag.P90, shard.Lag.P90)
+		stats.Lag.P99 = maxDuration(stats.Lag.P99, shard.Lag.P99)
+		stats.Lag.Max = maxDuration(stats.Lag.Max, shard.Lag.Max)
+		stats.Lag.Samples += shard.Lag.Samples
+	}
+
+	return stats
+}
+
+// shard returns the shard for the timer with the given key.
+func (stw *ShardedTimingWheel) shard(key string) *TimingWheel {
+	if len(stw.shards) == 1 {
+		return stw.shards[0]
+	}
+
+	if len(key) == 0 {
+		n := atomic.AddUint32(&stw.next, 1)
+		return stw.shards[n%uint32(len(stw.shards))]
+	}
+
+	return stw.shards[hashKey(key)%uint32(len(stw.shards))]
+}
+
+// hashKey hashes the key by FNV-1a, inlined to avoid allocating a hash.Hash32
+// and a copy of the key for each call.
+func hashKey(key string) uint32 {
+	h := uint32(fnvOffset32)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= fnvPrime32
+	}
+	return h
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+
+	return b
+}
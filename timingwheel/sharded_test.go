@@ -0,0 +1,48 @@
+package timingwheel
+
+import (
+	"fmt"
+	"hash/fnv"
+	"testing"
+	"time"
+)
+
+func TestShardByKey(t *testing.T) {
+	for _, key := range []string{"a", "timer-42", "定时器"} {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		if got, want := hashKey(key), h.Sum32(); got != want {
+			t.Fatalf("got the hash %d of %q, want %d", got, key, want)
+		}
+	}
+
+	stw := NewShardedTimingWheel(4, time.Millisecond, 20)
+	stw.Start()
+	defer stw.Stop()
+
+	stw.AfterFuncWith(time.Hour, "key", func() {})
+	if !stw.Exists("key") || !stw.StopByKey("key") {
+		t.Fatal("the timer is not found in the shard of its key")
+	}
+	if allocs := testing.AllocsPerRun(100, func() { stw.shard("key") }); allocs != 0 {
+		t.Fatalf("got %v allocations per shard lookup, want 0", allocs)
+	}
+}
+
+func BenchmarkShardedTimingWheel(b *testing.B) {
+	for _, shards := range []int{1, 2, 4, 8, 16} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			stw := NewShardedTimingWheel(shards, time.Millisecond, 20)
+			stw.Start()
+			defer stw.Stop()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					stw.AfterFunc(time.Minute, func() {}).Stop()
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package timingwheel
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// lagSamples is the number of the recent firing lags kept for the percentiles.
+const lagSamples = 1024
+
+type (
+	// Stats is a snapshot of the statistics of a TimingWheel.
+	Stats struct {
+		// Pending is the number of the timers waiting to fire.
+		Pending int
+		// Far is the number of the pending timers beyond the horizon,
+		// which are not in any level yet.
+		Far int
+		// Levels holds the statistics of each level of the timing wheel,
+		// the lowest-level wheel first, followed by the overflow wheels.
+		Levels []LevelStats
+		// Fired is the number of the timers fired so far, whose tasks have
+		// been dispatched.
+		Fired uint64
+		// Dropped is the number of the timers expired so far, whose tasks
+		// have been dropped by the OverflowDrop policy.
+		Dropped uint64
+		// Stopped is the number of the timers stopped before firing so far.
+		Stopped uint64
+		// Lag holds the percentiles of the recent firing lags.
+		Lag LagStats
+	}
+
+	// LevelStats is a snapshot of the statistics of one level of a TimingWheel.
+	LevelStats struct {
+		// Tick is the duration covered by each bucket of the level.
+		Tick time.Duration
+		// Pending is the number of the timers in the buckets of the level.
+		Pending int
+		// Buckets is the number of the non-empty buckets of the level.
+		Buckets int
+	}
+
+	// LagStats holds the percentiles of the firing lags, which are the delays
+	// between the expirations of the timers and the dispatching of their tasks.
+	LagStats struct {
+		P50     time.Duration
+		P90     time.Duration
+		P99     time.Duration
+		Max     time.Duration
+		Samples int
+	}
+
+	// Hooks are called on the events of a TimingWheel, so that its metrics
+	// can be exported. They are called synchronously, keep them fast.
+	Hooks struct {
+		// OnFire is called with the timer and its firing lag, once the task
+		// of the timer has been dispatched, but not if the task is dropped.
+		// The timers of PostFunc are reused once their tasks have been
+		// executed, so t must not be retained.
+		OnFire func(t *Timer, lag time.Duration)
+		// OnStop is called with the timer stopped before firing.
+		OnStop func(t *Timer)
+		// OnClockJump is called with the size of a wall-clock jump once it is
+		// detected, after the timers following the wall clock have been rebased.
+		OnClockJump func(jump time.Duration)
+	}
+
+	wheelStats struct {
+		// 64-bit atomic operations require 64-bit alignment, keep them first.
+		fired   uint64
+		stopped uint64
+		dropped uint64
+
+		lock sync.Mutex
+		lags []time.Duration
+		next int
+	}
+)
+
+func newWheelStats() *wheelStats {
+	return &wheelStats{
+		lags: make([]time.Duration, 0, lagSamples),
+	}
+}
+
+func (s *wheelStats) addFired(lag time.Duration) {
+	atomic.AddUint64(&s.fired, 1)
+
+	s.lock.Lock()
+	if len(s.lags) < lagSamples {
+		s.lags = append(s.lags, lag)
+	} else {
+		s.lags[s.next] = lag
+		s.next = (s.next + 1) % lagSamples
+	}
+	s.lock.Unlock()
+}
+
+func (s *wheelStats) addStopped() {
+	atomic.AddUint64(&s.stopped, 1)
+}
+
+func (s *wheelStats) addDropped() {
+	atomic.AddUint64(&s.dropped, 1)
+}
+
+func (s *wheelStats) lagStats() LagStats {
+	s.lock.Lock()
+	lags := make([]time.Duration, len(s.lags))
+	copy(lags, s.lags)
+	s.lock.Unlock()
+
+	if len(lags) == 0 {
+		return LagStats{}
+	}
+
+	sort.Slice(lags, func(i, j int) bool {
+		return lags[i] < lags[j]
+	})
+	percentile := func(p int) time.Duration {
+		return lags[(len(lags)-1)*p/100]
+	}
+	return LagStats{
+		P50:     percentile(50),
+		P90:     percentile(90),
+		P99:     percentile(99),
+		Max:     lags[len(lags)-1],
+		Samples: len(lags),
+	}
+}
+
+// Stats returns a snapshot of the statistics of the timing wheel.
+func (tw *TimingWheel) Stats() Stats {
+	stats := Stats{
+		Fired:   atomic.LoadUint64(&tw.stats.fired),
+		Stopped: atomic.LoadUint64(&tw.stats.stopped),
+		Dropped: atomic.LoadUint64(&tw.stats.dropped),
+		Lag:     tw.stats.lagStats(),
+	}
+
+	for w := tw; w != nil; w = (*TimingWheel)(atomic.LoadPointer(&w.overflowWheel)) {
+		level := LevelStats{
+			Tick: time.Duration(w.tick) * tw.unit,
+		}
+		for _, b := range w.buckets {
+			if n := b.Len(); n > 0 {
+				level.Pending += n
+				level.Buckets++
+			}
+		}
+		stats.Pending += level.Pending
+		stats.Levels = append(stats.Levels, level)
+	}
+	if tw.far != nil {
+		stats.Far = tw.far.Len()
+		stats.Pending += stats.Far
+	}
+
+	return stats
+}
+
+// fired records the firing of the timer t, which expired at the given expiration.
+func (tw *TimingWheel) fired(t *Timer, expiration int64) {
+	lag := tw.clock.Elapsed() - time.Duration(expiration)*tw.unit
+	if lag < 0 {
+		lag = 0
+	}
+
+	tw.stats.addFired(lag)
+	if tw.hooks.OnFire != nil {
+		tw.hooks.OnFire(t, lag)
+	}
+}
+
+// stopped records the stopping of the timer t before firing.
+func (tw *TimingWheel) stopped(t *Timer) {
+	tw.stats.addStopped()
+	if tw.hooks.OnStop != nil {
+		tw.hooks.OnStop(t)
+	}
+}
+
+// dropped records the dropping of the task of an expired timer.
+func (tw *TimingWheel) dropped() {
+	tw.stats.addDropped()
+}
@@ -0,0 +1,165 @@
+package timingwheel
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shanluzhineng/threadingx/timex"
+)
+
+func TestStatsExcludeDroppedTasks(t *testing.T) {
+	var hooked int32
+	tw := NewTimingWheel(time.Millisecond, 20, WithWorkerPool(1, 1, OverflowDrop),
+		WithHooks(Hooks{
+			OnFire: func(t *Timer, lag time.Duration) {
+				atomic.AddInt32(&hooked, 1)
+			},
+		}))
+	tw.Start()
+	defer tw.Stop()
+
+	var fired int32
+	releaseC := fillWorkerPool(t, tw, &fired)
+	for i := 0; i < 5; i++ {
+		tw.PostFunc(0, func() { atomic.AddInt32(&fired, 1) })
+	}
+	close(releaseC)
+	waitFor(t, func() bool {
+		return atomic.LoadInt32(&fired) == 2
+	})
+
+	stats := tw.Stats()
+	if stats.Fired != 2 || stats.Dropped != 5 {
+		t.Fatalf("got %d fired and %d dropped, want 2 and 5", stats.Fired, stats.Dropped)
+	}
+	if stats.Lag.Samples != 2 {
+		t.Fatalf("got %d lag samples, want 2", stats.Lag.Samples)
+	}
+	if n := atomic.LoadInt32(&hooked); n != 2 {
+		t.Fatalf("OnFire is called %d times, want 2", n)
+	}
+}
+
+func TestPanicHandler(t *testing.T) {
+	for _, pool := range []bool{false, true} {
+		name := "goroutine per task"
+		var opts []Option
+		if pool {
+			name = "worker pool"
+			opts = append(opts, WithWorkerPool(1, 8, OverflowBlock))
+		}
+
+		t.Run(name, func(t *testing.T) {
+			type report struct {
+				p     interface{}
+				stack []byte
+				key   string
+			}
+			reportC := make(chan report, 1)
+			tw := NewTimingWheel(time.Millisecond, 20, append(opts,
+				WithPanicHandler(func(p interface{}, stack []byte, key string) {
+					reportC <- report{p: p, stack: stack, key: key}
+				}))...)
+			tw.Start()
+			defer tw.Stop()
+
+			tw.AfterFuncWith(time.Millisecond, "bad", func() { panic("boom") })
+			var r report
+			select {
+			case r = <-reportC:
+			case <-time.After(time.Second):
+				t.Fatal("the panic handler is not called")
+			}
+			if r.p != "boom" || r.key != "bad" || len(r.stack) == 0 {
+				t.Fatalf("got the panic %v with the key %q and %d bytes of stack", r.p, r.key, len(r.stack))
+			}
+
+			// The timing wheel keeps running the other tasks.
+			firedC := make(chan struct{})
+			tw.AfterFunc(time.Millisecond, func() { close(firedC) })
+			select {
+			case <-firedC:
+			case <-time.After(time.Second):
+				t.Fatal("a task did not run after a panic")
+			}
+			if fired := tw.Stats().Fired; fired != 2 {
+				t.Fatalf("got %d fired, want 2", fired)
+			}
+		})
+	}
+}
+
+func TestStatsAndHooks(t *testing.T) {
+	var (
+		lock  sync.Mutex
+		lags  []time.Duration
+		stops []*Timer
+		jumps []time.Duration
+	)
+	clock := timex.NewFakeClock(time.Unix(1000, 0))
+	tw := NewTimingWheel(time.Millisecond, 20, WithClock(clock), WithHooks(Hooks{
+		OnFire: func(t *Timer, lag time.Duration) {
+			lock.Lock()
+			lags = append(lags, lag)
+			lock.Unlock()
+		},
+		OnStop: func(t *Timer) {
+			lock.Lock()
+			stops = append(stops, t)
+			lock.Unlock()
+		},
+		OnClockJump: func(jump time.Duration) {
+			lock.Lock()
+			jumps = append(jumps, jump)
+			lock.Unlock()
+		},
+	}))
+	tw.Start()
+	defer tw.Stop()
+
+	tw.AfterFunc(5*time.Millisecond, func() {})
+	tw.AfterFunc(time.Hour, func() {})
+	stopped := tw.AfterFunc(time.Hour, func() {})
+	stopped.Stop()
+
+	stats := tw.Stats()
+	if stats.Pending != 2 || stats.Stopped != 1 || stats.Fired != 0 {
+		t.Fatalf("got the stats %+v", stats)
+	}
+	if len(stats.Levels) < 2 || stats.Levels[0].Pending != 1 || stats.Levels[0].Buckets != 1 {
+		t.Fatalf("got the levels %+v", stats.Levels)
+	}
+
+	// Fires the first timer 5ms late.
+	advanceIdle(t, clock, 10*time.Millisecond)
+	waitFor(t, func() bool {
+		return tw.Stats().Fired == 1
+	})
+	stats = tw.Stats()
+	if stats.Pending != 1 || stats.Lag.Samples != 1 || stats.Lag.Max != 5*time.Millisecond {
+		t.Fatalf("got the stats %+v", stats)
+	}
+
+	// The jump is detected by the next maintenance.
+	clock.Jump(time.Hour)
+	advanceIdle(t, clock, time.Second)
+	waitFor(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(jumps) == 1
+	})
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(lags) != 1 || lags[0] != 5*time.Millisecond {
+		t.Fatalf("OnFire is called with the lags %v", lags)
+	}
+	if len(stops) != 1 || stops[0] != stopped {
+		t.Fatalf("OnStop is called with %v", stops)
+	}
+	if jumps[0] != time.Hour {
+		t.Fatalf("OnClockJump is called with %v, want 1h", jumps[0])
+	}
+}
@@ -2,68 +2,161 @@ package timingwheel
 
 import (
 	"errors"
+	"runtime/debug"
+	"sync"
 	"sync/atomic"
 	"time"
 	"unsafe"
 
+	"github.com/shanluzhineng/threadingx/timex"
 	"github.com/shanluzhineng/threadingx/timingwheel/delayqueue"
 )
 
+// maintainInterval is the interval of the maintenance of a TimingWheel.
+const maintainInterval = time.Second
+
 // TimingWheel is an implementation of Hierarchical Timing Wheels.
 type TimingWheel struct {
-	tick      int64 // in milliseconds
+	tick      int64 // in the time unit
 	wheelSize int64
 
-	interval    int64 // in milliseconds
-	currentTime int64 // in milliseconds
+	interval    int64 // in the time unit
+	currentTime int64 // in the time unit
 	buckets     []*bucket
-	queue       *delayqueue.DelayQueue
+	queue       *delayqueue.DelayQueue[*bucket]
 
 	// The higher-level overflow wheel.
 	//
 	// NOTE: This field may be updated and read concurrently, through Add().
 	overflowWheel unsafe.Pointer // type: *TimingWheel
+	// Guards the overflow wheels against being reclaimed while timers are
+	// added into them, only used by the lowest-level wheel.
+	levels *sync.RWMutex
+	// The number of the timers in the buckets of the wheel.
+	pending int32
+
+	// The horizon beyond which the timers are kept in the far bucket instead
+	// of the overflow wheels, in the time unit, 0 if there is no horizon.
+	// Only used by the lowest-level wheel.
+	horizon int64
+	far     *bucket
+	// The handle of the far bucket in the queue, guarded by far.mu.
+	farHandle *delayqueue.Handle[*bucket]
+
+	// The clock of the timing wheel, only used by the lowest-level wheel.
+	clock timex.Clock
+	// The unit of the times kept by the timing wheel, only used by the lowest-level wheel.
+	unit time.Duration
+	// The pool running the tasks of the expired timers, nil if each task
+	// runs in its own goroutine. Only used by the lowest-level wheel.
+	pool *workerPool
+	// The expired timers collected when flushing a bucket, only used by the
+	// goroutine of the lowest-level wheel.
+	expired []expiredTimer
+
+	// The statistics and the hooks, only used by the lowest-level wheel.
+	stats *wheelStats
+	hooks Hooks
+	// The handler of the panics of the tasks, only used by the lowest-level wheel.
+	onPanic func(p interface{}, stack []byte, key string)
+
+	// The index of the pending timers by their keys, only used by the lowest-level wheel.
+	registry *registry
+
+	// The tasks being run, only used by the lowest-level wheel.
+	tasks taskTracker
+	// Set to 1 once the timing wheel has been drained, only used by the lowest-level wheel.
+	// It is set with drainLock locked, while add checks it with drainLock read-locked,
+	// so that no timer is added once StopAndDrain has collected the pending ones.
+	drained   int32
+	drainLock sync.RWMutex
 
+	// Set to 1 once exitC has been closed.
+	exited    int32
 	exitC     chan struct{}
 	waitGroup waitGroupWrapper
 }
 
 // NewTimingWheel creates an instance of TimingWheel with the given tick and wheelSize.
-func NewTimingWheel(tick time.Duration, wheelSize int64) *TimingWheel {
-	tickMs := int64(tick / time.Millisecond)
-	if tickMs <= 0 {
-		panic(errors.New("tick must be greater than or equal to 1ms"))
+// The tick must be greater than or equal to the time unit, which is 1ms by default.
+func NewTimingWheel(tick time.Duration, wheelSize int64, opts ...Option) *TimingWheel {
+	options := newOptions()
+	for _, opt := range opts {
+		opt(&options)
 	}
 
-	startMs := timeToMs(time.Now().UTC())
+	tickUnits := int64(tick / options.unit)
+	if tickUnits <= 0 {
+		panic(errors.New("tick must be greater than or equal to the time unit"))
+	}
+
+	// The times are kept on the monotonic clock, so that the timers after
+	// a duration are not affected by the wall-clock jumps.
+	startUnits := int64(options.clock.Elapsed() / options.unit)
 
-	return newTimingWheel(
-		tickMs,
+	tw := newTimingWheel(
+		tickUnits,
 		wheelSize,
-		startMs,
-		delayqueue.New(int(wheelSize)),
+		startUnits,
+		delayqueue.New[*bucket](int(wheelSize), delayqueue.WithClock(options.clock), delayqueue.WithTimeUnit(options.unit)),
 	)
+	tw.clock = options.clock
+	tw.unit = options.unit
+	tw.levels = new(sync.RWMutex)
+	if options.horizon > 0 {
+		tw.horizon = int64(options.horizon / options.unit)
+		tw.far = newFarBucket()
+	}
+	tw.stats = newWheelStats()
+	tw.hooks = options.hooks
+	tw.onPanic = options.onPanic
+	tw.registry = newRegistry(options.duplicateKeyPolicy)
+	if options.workers > 0 {
+		tw.pool = newWorkerPool(options.workers, options.queueSize, options.overflowPolicy, options.onDrop,
+			tw.execute)
+	}
+	return tw
 }
 
 // newTimingWheel is an internal helper function that really creates an instance of TimingWheel.
-func newTimingWheel(tickMs int64, wheelSize int64, startMs int64, queue *delayqueue.DelayQueue) *TimingWheel {
-	buckets := make([]*bucket, wheelSize)
-	for i := range buckets {
-		buckets[i] = newBucket()
-	}
-	return &TimingWheel{
-		tick:        tickMs,
+func newTimingWheel(tick int64, wheelSize int64, start int64, queue *delayqueue.DelayQueue[*bucket]) *TimingWheel {
+	tw := &TimingWheel{
+		tick:        tick,
 		wheelSize:   wheelSize,
-		currentTime: truncate(startMs, tickMs),
-		interval:    tickMs * wheelSize,
-		buckets:     buckets,
+		currentTime: truncate(start, tick),
+		interval:    tick * wheelSize,
+		buckets:     make([]*bucket, wheelSize),
 		queue:       queue,
 		exitC:       make(chan struct{}),
 	}
+	for i := range tw.buckets {
+		tw.buckets[i] = newBucket(&tw.pending)
+	}
+	return tw
 }
 
-// add inserts the timer t into the current timing wheel.
+// add inserts the timer t into the timing wheel, or into the far bucket if t
+// expires beyond the horizon. It must be called on the lowest-level wheel.
+//
+// It returns false if t has already expired, or if the timing wheel has been
+// drained, see runOrDrop.
 func (tw *TimingWheel) add(t *Timer) bool {
+	tw.drainLock.RLock()
+	defer tw.drainLock.RUnlock()
+
+	if atomic.LoadInt32(&tw.drained) != 0 {
+		return false
+	}
+	if tw.horizon > 0 && t.expiration > tw.now()+tw.horizon {
+		tw.addFar(t)
+		return true
+	}
+
+	return tw.insert(t)
+}
+
+// insert inserts the timer t into the current timing wheel.
+func (tw *TimingWheel) insert(t *Timer) bool {
 	currentTime := atomic.LoadInt64(&tw.currentTime)
 	if t.expiration < currentTime+tw.tick {
 		// Already expired
@@ -88,6 +181,11 @@ func (tw *TimingWheel) add(t *Timer) bool {
 		return true
 	} else {
 		// Out of the interval. Put it into the overflow wheel
+		if tw.levels != nil {
+			tw.levels.RLock()
+			defer tw.levels.RUnlock()
+		}
+
 		overflowWheel := atomic.LoadPointer(&tw.overflowWheel)
 		if overflowWheel == nil {
 			atomic.CompareAndSwapPointer(
@@ -102,7 +200,7 @@ func (tw *TimingWheel) add(t *Timer) bool {
 			)
 			overflowWheel = atomic.LoadPointer(&tw.overflowWheel)
 		}
-		return (*TimingWheel)(overflowWheel).add(t)
+		return (*TimingWheel)(overflowWheel).insert(t)
 	}
 }
 
@@ -111,10 +209,104 @@ func (tw *TimingWheel) add(t *Timer) bool {
 func (tw *TimingWheel) addOrRun(t *Timer) {
 	if !tw.add(t) {
 		// Already expired
+		expiration := t.expiration
+		t.setBucket(nil)
+		tw.runOrDrop(t, expiration)
+	}
+}
+
+// runOrDrop runs the task of the timer t, which add has refused as expired at
+// the given expiration, unless the timing wheel has been drained, in which
+// case t is dropped without being fired.
+func (tw *TimingWheel) runOrDrop(t *Timer, expiration int64) {
+	if atomic.LoadInt32(&tw.drained) != 0 {
+		tw.registry.remove(t, t.GetKey())
+		return
+	}
+
+	tw.run(t, expiration)
+}
+
+// flush reinserts the timers of the bucket b into the current timing wheel,
+// and runs the tasks of the expired ones after b has been unlocked.
+func (tw *TimingWheel) flush(b *bucket) {
+	expired := tw.expired
+	b.Flush(func(t *Timer) {
+		if !tw.add(t) {
+			// Already expired
+			expired = append(expired, expiredTimer{timer: t, expiration: t.expiration})
+			t.setBucket(nil)
+		}
+	})
 
+	tw.runExpired(expired)
+}
+
+// runExpired runs the tasks of the expired timers collected by flush and
+// flushFar, and keeps the slice for reuse.
+func (tw *TimingWheel) runExpired(expired []expiredTimer) {
+	for i, e := range expired {
+		tw.run(e.timer, e.expiration)
+		expired[i] = expiredTimer{}
+	}
+	tw.expired = expired[:0]
+}
+
+// expiredTimer is an expired timer along with its expiration, which may be
+// changed by a concurrent Timer.Reset once the timer leaves its bucket.
+type expiredTimer struct {
+	timer      *Timer
+	expiration int64
+}
+
+// run runs the task of the timer t, which expired at the given expiration.
+func (tw *TimingWheel) run(t *Timer, expiration int64) {
+	if !t.recurring {
+		tw.registry.removeIfIdle(t, t.GetKey())
+	}
+	tw.tasks.add()
+
+	if tw.pool == nil {
+		tw.fired(t, expiration)
 		// Like the standard time.AfterFunc (https://golang.org/pkg/time/#AfterFunc),
 		// always execute the timer's task in its own goroutine.
-		go t.task()
+		go tw.execute(t)
+		return
+	}
+
+	if t.pooled {
+		// Hold t until the firing is recorded, since the task may have been
+		// executed and t reused by then.
+		atomic.StoreInt32(&t.refs, 2)
+	}
+	if tw.pool.submit(t) {
+		tw.fired(t, expiration)
+	} else {
+		tw.dropped()
+		tw.tasks.done()
+	}
+	if t.pooled {
+		releaseTimer(t)
+	}
+}
+
+// execute executes the task of the timer t, on behalf of run.
+func (tw *TimingWheel) execute(t *Timer) {
+	defer tw.tasks.done()
+	if t.pooled {
+		defer releaseTimer(t)
+	}
+	if tw.onPanic != nil {
+		defer tw.recoverTask(t)
+	}
+
+	t.task()
+}
+
+// recoverTask recovers from the panic of the task of t, and reports it to the panic handler.
+func (tw *TimingWheel) recoverTask(t *Timer) {
+	if p := recover(); p != nil {
+		tw.onPanic(p, debug.Stack(), t.GetKey())
 	}
 }
 
@@ -134,24 +326,58 @@ func (tw *TimingWheel) advanceClock(expiration int64) {
 
 // Start starts the current timing wheel.
 func (tw *TimingWheel) Start() {
+	if tw.pool != nil {
+		tw.pool.start()
+	}
+
 	tw.waitGroup.Wrap(func() {
 		tw.queue.Poll(tw.exitC, func() int64 {
-			return timeToMs(time.Now().UTC())
+			return tw.now()
 		})
 	})
 
 	tw.waitGroup.Wrap(func() {
 		for {
 			select {
-			case elem := <-tw.queue.C:
-				b := elem.(*bucket)
+			case b := <-tw.queue.C:
+				if b == tw.far {
+					tw.flushFar()
+					continue
+				}
+
 				tw.advanceClock(b.Expiration())
-				b.Flush(tw.addOrRun)
+				tw.flush(b)
 			case <-tw.exitC:
 				return
 			}
 		}
 	})
+
+	// Take the clock offset before returning, so that a jump right after
+	// Start is not missed.
+	offset := tw.clockOffset()
+	timer := tw.clock.NewTimer(maintainInterval)
+	tw.waitGroup.Wrap(func() {
+		tw.maintain(offset, timer)
+	})
+}
+
+// maintain checks the wall clock for jumps against the given offset, and
+// reclaims the idle overflow wheels, each time the timer fires.
+func (tw *TimingWheel) maintain(offset time.Duration, timer timex.Timer) {
+	var idle *TimingWheel
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.Chan():
+			offset = tw.checkClock(offset)
+			idle = tw.reclaim(idle)
+			timer.Reset(maintainInterval)
+		case <-tw.exitC:
+			return
+		}
+	}
 }
 
 // Stop stops the current timing wheel.
@@ -159,22 +385,59 @@ func (tw *TimingWheel) Start() {
 // If there is any timer's task being running in its own goroutine, Stop does
 // not wait for the task to complete before returning. If the caller needs to
 // know whether the task is completed, it must coordinate with the task explicitly.
+//
+// Stop does nothing if the timing wheel has been stopped or drained already.
 func (tw *TimingWheel) Stop() {
-	close(tw.exitC)
+	tw.exit()
+	if tw.pool != nil {
+		tw.pool.stop()
+	}
+}
+
+// exit closes exitC if not closed yet, and waits for the goroutines of the
+// timing wheel to return.
+func (tw *TimingWheel) exit() {
+	if atomic.CompareAndSwapInt32(&tw.exited, 0, 1) {
+		close(tw.exitC)
+	}
 	tw.waitGroup.Wait()
 }
 
 // AfterFunc waits for the duration to elapse and then calls f in its own goroutine.
 // It returns a Timer that can be used to cancel the call using its Stop method.
 func (tw *TimingWheel) AfterFunc(d time.Duration, f func()) *Timer {
+	return tw.AfterFuncWith(d, "", f)
+}
+
+// AfterFuncWith is like AfterFunc but sets the given key to the returned Timer,
+// which can be found by Lookup until it fires or is stopped.
+//
+// It returns nil if the key is rejected by the DuplicateReject policy.
+func (tw *TimingWheel) AfterFuncWith(d time.Duration, key string, f func()) *Timer {
 	t := &Timer{
-		expiration: timeToMs(time.Now().UTC().Add(d)),
+		key:        key,
+		expiration: tw.after(d),
 		task:       f,
+		tw:         tw,
+	}
+	if !tw.registry.add(t, key) {
+		return nil
 	}
 	tw.addOrRun(t)
 	return t
 }
 
+// PostFunc is like AfterFunc but returns no Timer, so the call can not be
+// cancelled. In return, the timer is taken from a pool and put back once f
+// has been called, which saves an allocation per call.
+func (tw *TimingWheel) PostFunc(d time.Duration, f func()) {
+	t := timerPool.Get().(*Timer)
+	t.expiration = tw.after(d)
+	t.task = f
+	t.tw = tw
+	tw.addOrRun(t)
+}
+
 // Scheduler determines the execution plan of a task.
 type Scheduler interface {
 	// Next returns the next execution time after the given (previous) time.
@@ -188,39 +451,16 @@ type Scheduler interface {
 // plan scheduled by s. It returns a Timer that can be used to cancel the
 // call using its Stop method.
 //
-// If the caller want to terminate the execution plan halfway, it must
-// stop the timer and ensure that the timer is stopped actually, since in
-// the current implementation, there is a gap between the expiring and the
-// restarting of the timer. The wait time for ensuring is short since the
-// gap is very small.
+// If the caller want to terminate the execution plan halfway, it can
+// stop the timer. Stop may return false if it is called in the gap between
+// the expiring and the restarting of the timer, but the timer will not be
+// restarted anyway.
 //
 // Internally, ScheduleFunc will ask the first execution time (by calling
 // s.Next()) initially, and create a timer if the execution time is non-zero.
 // Afterwards, it will ask the next execution time each time f is about to
 // be executed, and f will be called at the next execution time if the time
 // is non-zero.
-func (tw *TimingWheel) ScheduleFunc(s Scheduler, f func()) (t *Timer) {
-	expiration := s.Next(time.Now().UTC())
-	if expiration.IsZero() {
-		// No time is scheduled, return nil.
-		return
-	}
-
-	t = &Timer{
-		expiration: timeToMs(expiration),
-		task: func() {
-			// Schedule the task to execute at the next time if possible.
-			expiration := s.Next(msToTime(t.expiration))
-			if !expiration.IsZero() {
-				t.expiration = timeToMs(expiration)
-				tw.addOrRun(t)
-			}
-
-			// Actually execute the task.
-			f()
-		},
-	}
-	tw.addOrRun(t)
-
-	return
+func (tw *TimingWheel) ScheduleFunc(s Scheduler, f func(), opts ...ScheduleOption) (t *Timer) {
+	return tw.ScheduleFuncWith(s, "", f, opts...)
 }
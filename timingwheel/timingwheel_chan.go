@@ -0,0 +1,99 @@
+package timingwheel
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/shanluzhineng/threadingx/syncx"
+	"github.com/shanluzhineng/threadingx/timex"
+)
+
+// wheelTicker is a timex.Ticker backed by a TimingWheel.
+type wheelTicker struct {
+	c     chan time.Time
+	timer *Timer
+}
+
+// After waits for the duration to elapse and then sends the current time
+// on the returned channel, like time.After but without a runtime timer.
+func (tw *TimingWheel) After(d time.Duration) <-chan time.Time {
+	c := make(chan time.Time, 1)
+	tw.PostFunc(d, func() {
+		c <- tw.clock.Now()
+	})
+	return c
+}
+
+// AfterFuncCtx is like AfterFunc, but the timer is stopped once ctx is done,
+// and f is not called if ctx is done before the timer fires.
+//
+// Unless ctx can never be done, a goroutine watches ctx until the timer fires
+// or is stopped, so prefer AfterFunc with an explicit Timer.Stop for large
+// numbers of timers. A Timer.Reset while the timer is pending keeps ctx
+// watched, while a Timer.Reset once it has fired or been stopped does not
+// watch ctx again, though f is still not called if ctx is done by then.
+func (tw *TimingWheel) AfterFuncCtx(ctx context.Context, d time.Duration, f func()) *Timer {
+	doneC := make(chan struct{})
+	// Releases the watcher once the timer fires or is stopped.
+	release := syncx.Once(func() {
+		close(doneC)
+	})
+	t := tw.AfterFunc(d, func() {
+		release()
+		if ctx.Err() == nil {
+			f()
+		}
+	})
+
+	if ctx.Done() != nil {
+		t.mu.Lock()
+		t.onStop = release
+		t.mu.Unlock()
+
+		go func() {
+			select {
+			case <-ctx.Done():
+				select {
+				case <-doneC:
+					// Released meanwhile, t may have been reset since.
+				default:
+					t.Stop()
+				}
+			case <-doneC:
+			}
+		}()
+	}
+
+	return t
+}
+
+// NewTicker returns a timex.Ticker backed by the timing wheel, which sends
+// the current time on its channel every d. Like time.Ticker, the ticks are
+// dropped if the receiver falls behind. The d must be greater than 0.
+func (tw *TimingWheel) NewTicker(d time.Duration) timex.Ticker {
+	if d <= 0 {
+		panic(errors.New("non-positive interval for NewTicker"))
+	}
+
+	c := make(chan time.Time, 1)
+	t := tw.ScheduleFunc(Every(d), func() {
+		select {
+		case c <- tw.clock.Now():
+		default:
+		}
+	})
+
+	return &wheelTicker{
+		c:     c,
+		timer: t,
+	}
+}
+
+func (wt *wheelTicker) Chan() <-chan time.Time {
+	return wt.c
+}
+
+func (wt *wheelTicker) Stop() {
+	wt.timer.Stop()
+}
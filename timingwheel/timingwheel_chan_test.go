@@ -0,0 +1,108 @@
+package timingwheel
+
+import (
+	"context"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAfterFuncCtxReleasesWatcherOnStop(t *testing.T) {
+	tw := NewTimingWheel(time.Millisecond, 20)
+	tw.Start()
+	defer tw.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	before := runtime.NumGoroutine()
+	var fired int32
+	timers := make([]*Timer, 100)
+	for i := range timers {
+		timers[i] = tw.AfterFuncCtx(ctx, time.Hour, func() {
+			atomic.AddInt32(&fired, 1)
+		})
+	}
+	for _, timer := range timers {
+		if !timer.Stop() {
+			t.Fatal("Stop did not stop a pending timer")
+		}
+	}
+
+	waitFor(t, func() bool {
+		return runtime.NumGoroutine() <= before
+	})
+	if atomic.LoadInt32(&fired) != 0 {
+		t.Fatal("a stopped timer fired")
+	}
+}
+
+func TestAfterFuncCtxStopsOnDone(t *testing.T) {
+	tw := NewTimingWheel(time.Millisecond, 20)
+	tw.Start()
+	defer tw.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var fired int32
+	timer := tw.AfterFuncCtx(ctx, 20*time.Millisecond, func() {
+		atomic.AddInt32(&fired, 1)
+	})
+	cancel()
+
+	waitFor(t, func() bool {
+		return !timer.Stop()
+	})
+	time.Sleep(40 * time.Millisecond)
+	if atomic.LoadInt32(&fired) != 0 {
+		t.Fatal("the timer fired after ctx was done")
+	}
+}
+
+func TestAfterFuncCtxReset(t *testing.T) {
+	tw := NewTimingWheel(time.Millisecond, 20)
+	tw.Start()
+	defer tw.Stop()
+
+	t.Run("while pending", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		var fired int32
+		timer := tw.AfterFuncCtx(ctx, time.Hour, func() {
+			atomic.AddInt32(&fired, 1)
+		})
+		if !timer.Reset(50 * time.Millisecond) {
+			t.Fatal("Reset reported a pending timer as inactive")
+		}
+		cancel()
+
+		// Still watched, the timer is stopped by the cancellation.
+		waitFor(t, func() bool {
+			return !timer.Stop()
+		})
+		time.Sleep(70 * time.Millisecond)
+		if atomic.LoadInt32(&fired) != 0 {
+			t.Fatal("the timer fired after ctx was done")
+		}
+	})
+
+	t.Run("after stopped", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		var fired int32
+		timer := tw.AfterFuncCtx(ctx, time.Hour, func() {
+			atomic.AddInt32(&fired, 1)
+		})
+		timer.Stop()
+		before := tw.Stats().Fired
+		timer.Reset(20 * time.Millisecond)
+		cancel()
+
+		// Not watched anymore, the timer fires but does not call f.
+		waitFor(t, func() bool {
+			return tw.Stats().Fired > before
+		})
+		time.Sleep(10 * time.Millisecond)
+		if atomic.LoadInt32(&fired) != 0 {
+			t.Fatal("f is called after ctx was done")
+		}
+	})
+}
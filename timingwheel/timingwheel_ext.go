@@ -1,45 +1,121 @@
 package timingwheel
 
-import "time"
+import (
+	"sync/atomic"
+	"time"
+)
 
-// ScheduleFunc calls f (in its own goroutine) according to the execution
-// plan scheduled by s. It returns a Timer that can be used to cancel the
-// call using its Stop method.
+// ScheduleFuncWith is like ScheduleFunc but sets the given key to the returned Timer,
+// which can be found by Lookup until it is stopped or no time is scheduled anymore.
+// It returns nil if the key is rejected by the DuplicateReject policy.
 //
-// If the caller want to terminate the execution plan halfway, it must
-// stop the timer and ensure that the timer is stopped actually, since in
-// the current implementation, there is a gap between the expiring and the
-// restarting of the timer. The wait time for ensuring is short since the
-// gap is very small.
+// By default, a late timer fires all the runs it missed one after another,
+// use WithMisfirePolicy to coalesce or skip them instead.
+//
+// The timer follows the wall clock, even if it jumps, unless the times of s
+// are relative to the previous ones, like the ones of Every and Backoff.
+//
+// If the caller want to terminate the execution plan halfway, it can
+// stop the timer. Stop may return false if it is called in the gap between
+// the expiring and the restarting of the timer, but the timer will not be
+// restarted anyway.
 //
 // Internally, ScheduleFunc will ask the first execution time (by calling
 // s.Next()) initially, and create a timer if the execution time is non-zero.
 // Afterwards, it will ask the next execution time each time f is about to
 // be executed, and f will be called at the next execution time if the time
 // is non-zero.
-func (tw *TimingWheel) ScheduleFuncWith(s Scheduler, key string, f func()) (t *Timer) {
-	expiration := s.Next(time.Now().UTC())
+func (tw *TimingWheel) ScheduleFuncWith(s Scheduler, key string, f func(), opts ...ScheduleOption) (t *Timer) {
+	options := newScheduleOptions(opts)
+	relative := isRelative(s)
+	expiration := s.Next(tw.clock.Now().UTC())
 	if expiration.IsZero() {
 		// No time is scheduled, return nil.
 		return
 	}
 
 	t = &Timer{
-		expiration: timeToMs(expiration),
+		expiration: tw.wallToUnits(expiration),
+		// The time returned by s, which is passed back to s without being
+		// truncated to the time unit.
+		wall:     expiration,
+		absolute: !relative,
 		task: func() {
 			// Schedule the task to execute at the next time if possible.
-			expiration := s.Next(msToTime(t.expiration))
-			if !expiration.IsZero() {
-				t.expiration = timeToMs(expiration)
-				tw.addOrRun(t)
+			var expired, finished bool
+			var exp int64
+			var missed int
+			t.mu.Lock()
+			if t.cancelled || atomic.LoadInt32(&tw.drained) != 0 {
+				// t has been stopped, or the timing wheel has been drained.
+				finished = true
+			} else if t.getBucket() == nil {
+				// t has not been reset to a new expiration in the meantime.
+				prev, base := t.wall, t.expiration
+				if prev.IsZero() {
+					// t has been reset after a duration, continue the plan from there.
+					prev = tw.unitsToWall(base)
+				}
+				// toUnits maps the times of s onto the monotonic clock, the relative
+				// times follow prev, while the absolute ones follow the wall clock.
+				toUnits := func(next time.Time) int64 {
+					if relative {
+						return base + int64(next.Sub(prev)/tw.unit)
+					}
+					return tw.wallToUnits(next)
+				}
+
+				expiration := s.Next(prev)
+				if options.misfirePolicy != MisfireFireAll {
+					// Drop the times that are already due, since t is late.
+					now := tw.now()
+					for !expiration.IsZero() && toUnits(expiration) <= now {
+						missed++
+						expiration = s.Next(expiration)
+					}
+				}
+				if !expiration.IsZero() {
+					t.expiration = toUnits(expiration)
+					t.wall = expiration
+					t.absolute = !relative
+					exp = t.expiration
+					if !tw.add(t) {
+						// Already expired, unless the timing wheel has been drained in the meantime.
+						expired = atomic.LoadInt32(&tw.drained) == 0
+						finished = !expired
+					}
+				} else {
+					finished = true
+				}
+			}
+			key := t.key
+			t.mu.Unlock()
+			if expired {
+				tw.run(t, exp)
+			} else if finished {
+				tw.registry.remove(t, key)
+			}
+
+			if missed > 0 && options.misfirePolicy == MisfireSkip {
+				// The current run is late as well.
+				missed++
+			}
+			if missed > 0 && options.onMisfire != nil {
+				options.onMisfire(missed)
+			}
+			if missed > 0 && options.misfirePolicy == MisfireSkip {
+				return
 			}
 
 			// Actually execute the task.
 			f()
 		},
+		key:       key,
+		tw:        tw,
+		recurring: true,
 	}
-	if len(key) > 0 {
-		t.SetKey(key)
+	if !tw.registry.add(t, key) {
+		return nil
 	}
 	tw.addOrRun(t)
 
@@ -0,0 +1,113 @@
+package timingwheel
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shanluzhineng/threadingx/timex"
+)
+
+// advanceIdle waits for the goroutines of the timing wheel to wait on the
+// clock, then advances it by d.
+func advanceIdle(t *testing.T, clock timex.FakeClock, d time.Duration) {
+	t.Helper()
+	// The maintenance timer and the timer of the delay queue.
+	waitFor(t, func() bool {
+		return clock.Waiters() >= 2
+	})
+	clock.Advance(d)
+}
+
+func TestFakeClockFiresDueTimers(t *testing.T) {
+	clock := timex.NewFakeClock(time.Unix(1000, 0))
+	tw := NewTimingWheel(time.Millisecond, 20, WithClock(clock))
+	tw.Start()
+	defer tw.Stop()
+
+	var fired [5]int32
+	for i, d := range []time.Duration{5, 10, 50, 1000, 5000} {
+		i := i
+		tw.AfterFunc(d*time.Millisecond, func() {
+			atomic.AddInt32(&fired[i], 1)
+		})
+	}
+	expect := func(want [5]int32) {
+		t.Helper()
+		waitFor(t, func() bool {
+			for i := range fired {
+				if atomic.LoadInt32(&fired[i]) != want[i] {
+					return false
+				}
+			}
+			return true
+		})
+	}
+
+	advanceIdle(t, clock, 10*time.Millisecond)
+	expect([5]int32{1, 1, 0, 0, 0})
+	advanceIdle(t, clock, 990*time.Millisecond)
+	expect([5]int32{1, 1, 1, 1, 0})
+	advanceIdle(t, clock, 3999*time.Millisecond)
+	// Give the not yet due timer a chance to fire by mistake.
+	time.Sleep(20 * time.Millisecond)
+	expect([5]int32{1, 1, 1, 1, 0})
+	advanceIdle(t, clock, time.Millisecond)
+	expect([5]int32{1, 1, 1, 1, 1})
+}
+
+func TestFakeClockStopAndReset(t *testing.T) {
+	clock := timex.NewFakeClock(time.Unix(1000, 0))
+	tw := NewTimingWheel(time.Millisecond, 20, WithClock(clock))
+	tw.Start()
+	defer tw.Stop()
+
+	var stopped, reset int32
+	timer := tw.AfterFunc(10*time.Millisecond, func() { atomic.AddInt32(&stopped, 1) })
+	if !timer.Stop() {
+		t.Fatal("Stop did not stop a pending timer")
+	}
+	timer = tw.AfterFunc(10*time.Millisecond, func() { atomic.AddInt32(&reset, 1) })
+	if !timer.Reset(100 * time.Millisecond) {
+		t.Fatal("Reset did not find the timer active")
+	}
+
+	advanceIdle(t, clock, 50*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if atomic.LoadInt32(&stopped) != 0 || atomic.LoadInt32(&reset) != 0 {
+		t.Fatal("a timer fired before it was due")
+	}
+
+	advanceIdle(t, clock, 50*time.Millisecond)
+	waitFor(t, func() bool {
+		return atomic.LoadInt32(&reset) == 1
+	})
+	if atomic.LoadInt32(&stopped) != 0 {
+		t.Fatal("a stopped timer fired")
+	}
+}
+
+func TestPostFuncAllocs(t *testing.T) {
+	tw := NewTimingWheel(time.Millisecond, 20)
+	tw.Start()
+	defer tw.Stop()
+
+	const warm = 2000
+	var fired int32
+	f := func() {
+		atomic.AddInt32(&fired, 1)
+	}
+	// Fill the pool with the timers of the fired tasks.
+	for i := 0; i < warm; i++ {
+		tw.PostFunc(time.Millisecond, f)
+	}
+	waitFor(t, func() bool {
+		return atomic.LoadInt32(&fired) == warm
+	})
+
+	if allocs := testing.AllocsPerRun(warm/2, func() {
+		tw.PostFunc(time.Minute, f)
+	}); allocs != 0 {
+		t.Fatalf("PostFunc allocates %v times per call, want 0", allocs)
+	}
+}
@@ -1,9 +1,6 @@
 package timingwheel
 
-import (
-	"sync"
-	"time"
-)
+import "sync"
 
 // truncate returns the result of rounding x toward zero to a multiple of m.
 // If m <= 0, Truncate returns x unchanged.
@@ -14,17 +11,6 @@ func truncate(x, m int64) int64 {
 	return x - x%m
 }
 
-// timeToMs returns an integer number, which represents t in milliseconds.
-func timeToMs(t time.Time) int64 {
-	return t.UnixNano() / int64(time.Millisecond)
-}
-
-// msToTime returns the UTC time corresponding to the given Unix time,
-// t milliseconds since January 1, 1970 UTC.
-func msToTime(t int64) time.Time {
-	return time.Unix(0, t*int64(time.Millisecond)).UTC()
-}
-
 type waitGroupWrapper struct {
 	sync.WaitGroup
 }
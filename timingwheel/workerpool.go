@@ -0,0 +1,148 @@
+package timingwheel
+
+import (
+	"sync"
+
+	"github.com/shanluzhineng/threadingx/rescue"
+)
+
+// OverflowPolicy decides what to do with the task of an expired timer when
+// the queue of the worker pool is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits until the queue has room for the task, or runs the
+	// task in its own goroutine if the workers have not been started yet.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowRunInline runs the task in the goroutine dispatching it, which
+	// is the timing wheel's goroutine for the timers expired in the wheel.
+	OverflowRunInline
+	// OverflowDrop drops the task, and reports the timer to the drop handler if any.
+	OverflowDrop
+)
+
+// workerPool runs the tasks of the expired timers on a bounded number of goroutines.
+type workerPool struct {
+	workers int
+	policy  OverflowPolicy
+	onDrop  func(*Timer)
+	execute func(*Timer)
+	tasks   chan *Timer
+	// spaceC is signaled once a worker takes a task out of the queue, to wake
+	// up a submission waiting for room by the OverflowBlock policy.
+	spaceC chan struct{}
+	// stopC is closed once the pool is stopped.
+	stopC chan struct{}
+
+	// lock guards the closing of tasks against the concurrent submissions.
+	lock    sync.RWMutex
+	started bool
+	stopped bool
+}
+
+func newWorkerPool(workers, queueSize int, policy OverflowPolicy, onDrop func(*Timer),
+	execute func(*Timer)) *workerPool {
+	return &workerPool{
+		workers: workers,
+		policy:  policy,
+		onDrop:  onDrop,
+		execute: execute,
+		tasks:   make(chan *Timer, queueSize),
+		spaceC:  make(chan struct{}, 1),
+		stopC:   make(chan struct{}),
+	}
+}
+
+// start starts the workers, the tasks submitted before are queued until then.
+func (p *workerPool) start() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.started || p.stopped {
+		return
+	}
+	p.started = true
+
+	for i := 0; i < p.workers; i++ {
+		go func() {
+			for t := range p.tasks {
+				select {
+				case p.spaceC <- struct{}{}:
+				default:
+				}
+				p.run(t)
+			}
+		}()
+	}
+}
+
+// stop lets the workers exit after running the queued tasks.
+func (p *workerPool) stop() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.stopped {
+		return
+	}
+	p.stopped = true
+	if !p.started {
+		// nobody is going to run the queued tasks, run them in their own goroutines.
+		for len(p.tasks) > 0 {
+			go p.run(<-p.tasks)
+		}
+	}
+	close(p.tasks)
+	close(p.stopC)
+}
+
+// submit queues the task of t, or applies the overflow policy if the queue is full.
+// It returns false if the task is dropped.
+func (p *workerPool) submit(t *Timer) bool {
+	for {
+		p.lock.RLock()
+		if p.stopped {
+			p.lock.RUnlock()
+			// the pool is gone with the timing wheel, fall back to a goroutine per task.
+			go p.run(t)
+			return true
+		}
+
+		select {
+		case p.tasks <- t:
+			p.lock.RUnlock()
+			return true
+		default:
+		}
+		started := p.started
+		p.lock.RUnlock()
+
+		// Apply the policy without holding the lock, so that neither a blocked
+		// submission nor a task run inline can block stop.
+		switch {
+		case p.policy == OverflowRunInline:
+			p.run(t)
+			return true
+		case p.policy == OverflowDrop:
+			if p.onDrop != nil {
+				p.onDrop(t)
+			}
+			return false
+		case !started:
+			// no worker is going to make room in the queue.
+			go p.run(t)
+			return true
+		}
+
+		select {
+		case <-p.spaceC:
+		case <-p.stopC:
+		}
+	}
+}
+
+// run runs the task of t, recovers if it panics.
+func (p *workerPool) run(t *Timer) {
+	defer rescue.Recover()
+
+	p.execute(t)
+}
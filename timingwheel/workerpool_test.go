@@ -0,0 +1,119 @@
+package timingwheel
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerPoolBlockBeforeStart(t *testing.T) {
+	tw := NewTimingWheel(time.Millisecond, 20, WithWorkerPool(1, 1, OverflowBlock))
+
+	var fired int32
+	doneC := make(chan struct{})
+	go func() {
+		defer close(doneC)
+		// The queue is full from the second task, and no worker is running.
+		for i := 0; i < 3; i++ {
+			tw.AfterFunc(0, func() { atomic.AddInt32(&fired, 1) })
+		}
+		tw.Stop()
+	}()
+
+	select {
+	case <-doneC:
+	case <-time.After(5 * time.Second):
+		t.Fatal("blocked on a full queue without workers")
+	}
+	waitFor(t, func() bool {
+		return atomic.LoadInt32(&fired) == 3
+	})
+}
+
+// fillWorkerPool holds the only worker of tw by a task, and fills the queue
+// of size 1 by another one, both done once releaseC is closed.
+func fillWorkerPool(t *testing.T, tw *TimingWheel, fired *int32) (releaseC chan struct{}) {
+	t.Helper()
+	releaseC = make(chan struct{})
+	runningC := make(chan struct{})
+	tw.AfterFunc(0, func() {
+		close(runningC)
+		<-releaseC
+		atomic.AddInt32(fired, 1)
+	})
+	<-runningC
+	tw.AfterFunc(0, func() { atomic.AddInt32(fired, 1) })
+	return releaseC
+}
+
+func TestWorkerPoolOverflowBlock(t *testing.T) {
+	tw := NewTimingWheel(time.Millisecond, 20, WithWorkerPool(1, 1, OverflowBlock))
+	tw.Start()
+	defer tw.Stop()
+
+	var fired int32
+	releaseC := fillWorkerPool(t, tw, &fired)
+	submittedC := make(chan struct{})
+	go func() {
+		tw.AfterFunc(0, func() { atomic.AddInt32(&fired, 1) })
+		close(submittedC)
+	}()
+
+	select {
+	case <-submittedC:
+		t.Fatal("submitted into a full queue")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(releaseC)
+	<-submittedC
+	waitFor(t, func() bool {
+		return atomic.LoadInt32(&fired) == 3
+	})
+}
+
+func TestWorkerPoolOverflowDrop(t *testing.T) {
+	var dropped int32
+	tw := NewTimingWheel(time.Millisecond, 20, WithWorkerPool(1, 1, OverflowDrop),
+		WithDropHandler(func(t *Timer) {
+			if t.GetKey() == "overflow" {
+				atomic.AddInt32(&dropped, 1)
+			}
+		}))
+	tw.Start()
+	defer tw.Stop()
+
+	var fired int32
+	releaseC := fillWorkerPool(t, tw, &fired)
+	tw.AfterFuncWith(0, "overflow", func() { atomic.AddInt32(&fired, 1) })
+	if atomic.LoadInt32(&dropped) != 1 {
+		t.Fatal("the drop handler is not called with the overflowing timer")
+	}
+
+	close(releaseC)
+	waitFor(t, func() bool {
+		return atomic.LoadInt32(&fired) == 2
+	})
+	time.Sleep(10 * time.Millisecond)
+	if n := atomic.LoadInt32(&fired); n != 2 {
+		t.Fatalf("%d tasks ran, want the overflowing one dropped", n)
+	}
+}
+
+func TestWorkerPoolOverflowRunInline(t *testing.T) {
+	tw := NewTimingWheel(time.Millisecond, 20, WithWorkerPool(1, 1, OverflowRunInline))
+	tw.Start()
+	defer tw.Stop()
+
+	var fired int32
+	releaseC := fillWorkerPool(t, tw, &fired)
+	var inline int32
+	tw.AfterFunc(0, func() { atomic.StoreInt32(&inline, 1) })
+	if atomic.LoadInt32(&inline) != 1 {
+		t.Fatal("the overflowing task is not run by the submitting goroutine")
+	}
+
+	close(releaseC)
+	waitFor(t, func() bool {
+		return atomic.LoadInt32(&fired) == 2
+	})
+}